@@ -0,0 +1,345 @@
+package iradix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+)
+
+// Codec serializes trees to a binary format. Since T is arbitrary, values
+// are converted with the callbacks passed to NewCodec.
+//
+// The format is a sequence of entries in key order, each consisting of the
+// uvarint-prefixed key followed by the uvarint-prefixed encoded value.
+type Codec[T any] struct {
+	encodeVal func(T) ([]byte, error)
+	decodeVal func([]byte) (T, error)
+}
+
+// NewCodec returns a codec that converts values to bytes with encodeVal and
+// back with decodeVal.
+func NewCodec[T any](encodeVal func(T) ([]byte, error), decodeVal func([]byte) (T, error)) *Codec[T] {
+	return &Codec[T]{encodeVal: encodeVal, decodeVal: decodeVal}
+}
+
+// Marshal encodes all entries of i.
+func (c *Codec[T]) Marshal(i *Iradix[T]) ([]byte, error) {
+	var data []byte
+	for key, val := range i.Iterate() {
+		encoded, err := c.encodeVal(val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode value for key %q: %w", key, err)
+		}
+		data = binary.AppendUvarint(data, uint64(len(key)))
+		data = append(data, key...)
+		data = binary.AppendUvarint(data, uint64(len(encoded)))
+		data = append(data, encoded...)
+	}
+	return data, nil
+}
+
+// Unmarshal decodes a tree from data produced by Marshal.
+func (c *Codec[T]) Unmarshal(data []byte) (*Iradix[T], error) {
+	var err error
+	tree := New[T]().InsertMany(func(yield func([]byte, T) bool) {
+		for len(data) > 0 {
+			var key, encoded []byte
+			if key, data, err = readChunk(data); err != nil {
+				err = fmt.Errorf("failed to read key: %w", err)
+				return
+			}
+			if encoded, data, err = readChunk(data); err != nil {
+				err = fmt.Errorf("failed to read value for key %q: %w", key, err)
+				return
+			}
+			val, decodeErr := c.decodeVal(encoded)
+			if decodeErr != nil {
+				err = fmt.Errorf("failed to decode value for key %q: %w", key, decodeErr)
+				return
+			}
+			if !yield(key, val) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
+
+// readChunk reads a uvarint-prefixed chunk from data and returns it along
+// with the remaining data.
+func readChunk(data []byte) (chunk, rest []byte, err error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, nil, errors.New("invalid length prefix")
+	}
+	data = data[n:]
+	if uint64(len(data)) < length {
+		return nil, nil, fmt.Errorf("length %d exceeds remaining %d bytes", length, len(data))
+	}
+	return data[:length], data[length:], nil
+}
+
+// Encode writes all entries of i to w in key order, using the same format as
+// Codec.Marshal. Values are written by encodeVal, entries are streamed
+// so only a single encoded value is buffered at a time.
+func (i *Iradix[T]) Encode(w io.Writer, encodeVal func(T, io.Writer) error) error {
+	bw := bufio.NewWriter(w)
+	var encoded bytes.Buffer
+	var lengthBuf []byte
+	for key, val := range i.Iterate() {
+		encoded.Reset()
+		if err := encodeVal(val, &encoded); err != nil {
+			return fmt.Errorf("failed to encode value for key %q: %w", key, err)
+		}
+		lengthBuf = binary.AppendUvarint(lengthBuf[:0], uint64(len(key)))
+		lengthBuf = append(lengthBuf, key...)
+		lengthBuf = binary.AppendUvarint(lengthBuf, uint64(encoded.Len()))
+		if _, err := bw.Write(lengthBuf); err != nil {
+			return fmt.Errorf("failed to write key %q: %w", key, err)
+		}
+		if _, err := encoded.WriteTo(bw); err != nil {
+			return fmt.Errorf("failed to write value for key %q: %w", key, err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
+	return nil
+}
+
+// Decode reads a tree written by Encode from r until it is exhausted and
+// builds it with BuildSorted. decodeVal is passed a reader limited to the
+// encoded value, any part of it left unread is skipped.
+func Decode[T any](r io.Reader, decodeVal func(io.Reader) (T, error)) (*Iradix[T], error) {
+	br := bufio.NewReader(r)
+	var err error
+	tree, buildErr := BuildSorted(func(yield func([]byte, T) bool) {
+		for {
+			if _, err = br.Peek(1); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				return
+			}
+
+			var key []byte
+			if key, err = readStreamChunk(br); err != nil {
+				err = fmt.Errorf("failed to read key: %w", err)
+				return
+			}
+			var valLen uint64
+			if valLen, err = binary.ReadUvarint(br); err != nil {
+				err = fmt.Errorf("failed to read value length for key %q: %w", key, noEOF(err))
+				return
+			}
+			var valReader *io.LimitedReader
+			if valReader, err = limitReader(br, valLen); err != nil {
+				err = fmt.Errorf("failed to read value for key %q: %w", key, err)
+				return
+			}
+			val, decodeErr := decodeVal(valReader)
+			if decodeErr != nil {
+				err = fmt.Errorf("failed to decode value for key %q: %w", key, decodeErr)
+				return
+			}
+			if _, err = io.Copy(io.Discard, valReader); err != nil {
+				err = fmt.Errorf("failed to skip value for key %q: %w", key, err)
+				return
+			}
+			if valReader.N > 0 {
+				err = fmt.Errorf("failed to read value for key %q: %w", key, io.ErrUnexpectedEOF)
+				return
+			}
+			if !yield(key, val) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if buildErr != nil {
+		return nil, buildErr
+	}
+	return tree, nil
+}
+
+// readStreamChunk reads a uvarint-prefixed chunk from r. The chunk is read
+// incrementally, so a corrupt length can't cause a huge allocation.
+func readStreamChunk(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	chunkReader, err := limitReader(r, length)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := io.ReadAll(chunkReader)
+	if err != nil {
+		return nil, err
+	}
+	if chunkReader.N > 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return chunk, nil
+}
+
+// limitReader returns a reader that reads at most length bytes from r.
+func limitReader(r io.Reader, length uint64) (*io.LimitedReader, error) {
+	if length > math.MaxInt64 {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+	return &io.LimitedReader{R: r, N: int64(length)}, nil
+}
+
+// noEOF converts io.EOF into io.ErrUnexpectedEOF for reads within an entry.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// gobEntry is the wire representation of a single entry used by GobEncode.
+type gobEntry[T any] struct {
+	Key []byte
+	Val T
+}
+
+// GobEncode implements gob.GobEncoder. The entries are encoded in key order
+// as a flat list, so T must itself be encodable by encoding/gob.
+func (i *Iradix[T]) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	for key, val := range i.Iterate() {
+		if err := enc.Encode(gobEntry[T]{Key: key, Val: val}); err != nil {
+			return nil, fmt.Errorf("failed to encode entry for key %q: %w", key, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder. Any previous content of i is
+// replaced, its configuration is retained. If a key occurs multiple times,
+// the last value wins.
+func (i *Iradix[T]) GobDecode(data []byte) error {
+	empty := i.empty()
+	t := empty.txn()
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	for {
+		var entry gobEntry[T]
+		if err := dec.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to decode entry: %w", err)
+		}
+		if err := i.cfg.checkKeyLen(entry.Key); err != nil {
+			return err
+		}
+		t.overwrite(entry.Key, entry.Val)
+	}
+
+	*i = *t.commit(empty)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. The tree is encoded as an object
+// mapping string(key) to the value, with members in key order.
+//
+// JSON strings hold UTF-8 text, so keys that aren't valid UTF-8 get their
+// invalid bytes replaced and won't round-trip. Use a Codec for binary keys.
+func (i *Iradix[T]) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for key, val := range i.Iterate() {
+		if len(buf) > 1 {
+			buf = append(buf, ',')
+		}
+		encodedKey, err := json.Marshal(string(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode key %q: %w", key, err)
+		}
+		encodedVal, err := json.Marshal(val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode value for key %q: %w", key, err)
+		}
+		buf = append(buf, encodedKey...)
+		buf = append(buf, ':')
+		buf = append(buf, encodedVal...)
+	}
+	return append(buf, '}'), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler for objects produced by
+// MarshalJSON. Any previous content of i is replaced, its configuration is
+// retained. If a key occurs multiple times, the last value wins, also in
+// trees configured with WithOnConflict.
+func (i *Iradix[T]) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return fmt.Errorf("failed to read object start: %w", err)
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("expected object, got %v", tok)
+	}
+
+	empty := i.empty()
+	t := empty.txn()
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("failed to read key: %w", err)
+		}
+		key := []byte(tok.(string))
+		if err := i.cfg.checkKeyLen(key); err != nil {
+			return err
+		}
+		var val T
+		if err := dec.Decode(&val); err != nil {
+			return fmt.Errorf("failed to decode value for key %q: %w", key, err)
+		}
+		t.overwrite(key, val)
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("failed to read object end: %w", err)
+	}
+
+	*i = *t.commit(empty)
+	return nil
+}
+
+// Fingerprint returns a 64-bit FNV-1a hash of all keys and their values as
+// returned by hashVal, which must encode equal values to equal bytes. Entries
+// are hashed in key order with every key and value length-prefixed, so trees
+// holding the same entries have the same fingerprint regardless of how they
+// were built. Different contents collide with a probability of about 2^-64
+// per pair of trees, which is good enough to detect accidental divergence
+// but offers no protection against deliberately crafted collisions.
+func (i *Iradix[T]) Fingerprint(hashVal func(T) []byte) uint64 {
+	h := fnv.New64a()
+	var buf []byte
+	for key, val := range i.Iterate() {
+		hashed := hashVal(val)
+		buf = binary.AppendUvarint(buf[:0], uint64(len(key)))
+		buf = append(buf, key...)
+		buf = binary.AppendUvarint(buf, uint64(len(hashed)))
+		buf = append(buf, hashed...)
+		h.Write(buf)
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,349 @@
+package iradix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	codec := NewCodec(
+		func(v int) ([]byte, error) { return binary.AppendVarint(nil, int64(v)), nil },
+		func(data []byte) (int, error) {
+			v, n := binary.Varint(data)
+			if n <= 0 {
+				return 0, errors.New("invalid varint")
+			}
+			return int(v), nil
+		},
+	)
+
+	testCases := []struct {
+		name string
+		tree *Iradix[int]
+	}{
+		{
+			name: "Empty tree",
+			tree: New[int](),
+		},
+		{
+			name: "Empty key only",
+			tree: NewFromMap(map[string]int{"": 1}),
+		},
+		{
+			name: "Compressed paths and binary keys",
+			tree: NewFromMap(map[string]int{
+				"":                        -1,
+				"namespace":               2,
+				"namespace/pod-1":         300,
+				"namespace/pod-2/owner-1": -40000,
+				"namespaces":              5,
+				"\x00\xff":                6,
+			}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := codec.Marshal(tc.tree)
+			require.NoError(t, err)
+
+			decoded, err := codec.Unmarshal(data)
+			require.NoError(t, err)
+			validateTree(t, decoded)
+			require.Equal(t, tc.tree.Len(), decoded.Len())
+			require.Equal(t, tc.tree.ToMap(), decoded.ToMap())
+
+			if len(data) > 0 {
+				_, err = codec.Unmarshal(data[:len(data)-1])
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestCodecValueErrors(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{"foo": "foo-val"})
+	codec := NewCodec(
+		func(v string) ([]byte, error) { return nil, errors.New("nope") },
+		func(data []byte) (string, error) { return "", errors.New("nope") },
+	)
+
+	_, err := codec.Marshal(tree)
+	require.ErrorContains(t, err, `failed to encode value for key "foo"`)
+
+	_, err = codec.Unmarshal([]byte("\x03foo\x00"))
+	require.ErrorContains(t, err, `failed to decode value for key "foo"`)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	t.Parallel()
+
+	encodeVal := func(v string, w io.Writer) error {
+		_, err := io.WriteString(w, v)
+		return err
+	}
+	decodeVal := func(r io.Reader) (string, error) {
+		data, err := io.ReadAll(r)
+		return string(data), err
+	}
+
+	tree := NewFromMap(map[string]string{
+		"":                        "empty-val",
+		"namespace":               "namespace-val",
+		"namespace/pod-1":         "pod-1-val",
+		"namespace/pod-2/owner-1": strings.Repeat("x", 10_000),
+		"namespaces":              "",
+		"\x00\xff":                "binary-val",
+	})
+
+	t.Run("Buffer", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		require.NoError(t, tree.Encode(&buf, encodeVal))
+		data := slices.Clone(buf.Bytes())
+
+		decoded, err := Decode(&buf, decodeVal)
+		require.NoError(t, err)
+		validateTree(t, decoded)
+		require.Equal(t, tree.ToMap(), decoded.ToMap())
+
+		// The format is the one of Codec.
+		codec := NewCodec(
+			func(v string) ([]byte, error) { return []byte(v), nil },
+			func(data []byte) (string, error) { return string(data), nil },
+		)
+		marshaled, err := codec.Marshal(tree)
+		require.NoError(t, err)
+		require.Equal(t, marshaled, data)
+
+		for _, truncated := range [][]byte{data[:1], data[:len(data)-1], data[:len(data)-10_001]} {
+			_, err = Decode(bytes.NewReader(truncated), decodeVal)
+			require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		}
+	})
+
+	t.Run("Pipe", func(t *testing.T) {
+		t.Parallel()
+
+		r, w := io.Pipe()
+		go func() {
+			w.CloseWithError(tree.Encode(w, encodeVal))
+		}()
+
+		decoded, err := Decode(r, decodeVal)
+		require.NoError(t, err)
+		validateTree(t, decoded)
+		require.Equal(t, tree.ToMap(), decoded.ToMap())
+	})
+
+	t.Run("Empty tree", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		require.NoError(t, New[string]().Encode(&buf, encodeVal))
+		require.Zero(t, buf.Len())
+
+		decoded, err := Decode(&buf, decodeVal)
+		require.NoError(t, err)
+		validateTree(t, decoded)
+		require.Zero(t, decoded.Len())
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+
+		failing := func(string, io.Writer) error { return errors.New("nope") }
+		require.ErrorContains(t, tree.Encode(io.Discard, failing), `failed to encode value for key ""`)
+		require.ErrorContains(t, tree.Encode(failingWriter{}, encodeVal), "failed to")
+
+		// Partially read values are skipped.
+		decoded, err := Decode(bytes.NewReader([]byte("\x01a\x03abc\x01b\x01d")), func(r io.Reader) (byte, error) {
+			var b [1]byte
+			_, err := io.ReadFull(r, b[:])
+			return b[0], err
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string]byte{"a": 'a', "b": 'd'}, decoded.ToMap())
+
+		_, err = Decode(bytes.NewReader([]byte("\x01b\x00\x01a\x00")), decodeVal)
+		require.EqualError(t, err, `key "a" is not greater than the previous key "b"`)
+	})
+}
+
+func TestGobRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	type owner struct {
+		Name   string
+		Labels map[string]string
+		Ports  []int
+	}
+	type state struct {
+		Version int
+		Tree    *Iradix[owner]
+	}
+
+	tree := NewFromMap(map[string]owner{
+		"":                {Name: "root"},
+		"namespace":       {Name: "ns", Labels: map[string]string{"team": "a"}},
+		"namespace/pod-1": {Name: "pod-1", Ports: []int{80, 443}},
+		"namespace/pod-2": {Name: "pod-2", Labels: map[string]string{"app": "web"}, Ports: []int{8080}},
+		"\x00\xff":        {Name: "binary"},
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(state{Version: 3, Tree: tree}))
+
+	var decoded state
+	require.NoError(t, gob.NewDecoder(&buf).Decode(&decoded))
+	require.Equal(t, 3, decoded.Version)
+	validateTree(t, decoded.Tree)
+	require.Equal(t, tree.Len(), decoded.Tree.Len())
+	require.Equal(t, tree.ToMap(), decoded.Tree.ToMap())
+}
+
+func TestGobDecodeRetainsConfig(t *testing.T) {
+	t.Parallel()
+
+	data, err := NewFromMap(map[string]int{"foo": 1, "bar": 2}).GobEncode()
+	require.NoError(t, err)
+
+	decoded := NewWithOptions(WithComparable[int]())
+	require.NoError(t, decoded.GobDecode(data))
+	validateTree(t, decoded)
+	require.Equal(t, map[string]int{"foo": 1, "bar": 2}, decoded.ToMap())
+
+	// The comparable config skips the write for an identical value.
+	_, _, updated := decoded.Insert([]byte("foo"), 1)
+	require.Same(t, decoded, updated)
+
+	require.Error(t, decoded.GobDecode(data[:len(data)-1]))
+
+	// Duplicate keys are overwritten rather than resolved as conflicts.
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	require.NoError(t, enc.Encode(gobEntry[int]{Key: []byte("foo"), Val: 1}))
+	require.NoError(t, enc.Encode(gobEntry[int]{Key: []byte("foo"), Val: 2}))
+	sum := NewWithOptions(WithOnConflict(func(old, new int) int { return old + new }))
+	require.NoError(t, sum.GobDecode(buf.Bytes()))
+	require.Equal(t, map[string]int{"foo": 2}, sum.ToMap())
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	type pod struct {
+		Name  string `json:"name"`
+		Ports []int  `json:"ports,omitempty"`
+	}
+
+	testCases := []struct {
+		name     string
+		tree     *Iradix[pod]
+		expected string
+	}{
+		{
+			name:     "Empty tree",
+			tree:     New[pod](),
+			expected: `{}`,
+		},
+		{
+			name: "Members are in key order",
+			tree: NewFromMap(map[string]pod{
+				"namespace/pod-2": {Name: "pod-2", Ports: []int{8080}},
+				"namespace/pod-1": {Name: "pod-1"},
+				"":                {Name: "root"},
+				"namespaces":      {Name: "other"},
+			}),
+			expected: `{"":{"name":"root"},"namespace/pod-1":{"name":"pod-1"},"namespace/pod-2":{"name":"pod-2","ports":[8080]},"namespaces":{"name":"other"}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := json.Marshal(tc.tree)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(data))
+
+			var decoded *Iradix[pod]
+			require.NoError(t, json.Unmarshal(data, &decoded))
+			validateTree(t, decoded)
+			require.Equal(t, tc.tree.Len(), decoded.Len())
+			require.Equal(t, tc.tree.ToMap(), decoded.ToMap())
+		})
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	decoded := New[int]()
+	require.NoError(t, json.Unmarshal([]byte(`{"foo": 1, "bar": 2, "foo": 3}`), decoded))
+	validateTree(t, decoded)
+	require.Equal(t, map[string]int{"foo": 3, "bar": 2}, decoded.ToMap())
+
+	require.ErrorContains(t, json.Unmarshal([]byte(`[1]`), decoded), "expected object")
+	require.ErrorContains(t, json.Unmarshal([]byte(`{"foo": "bar"}`), decoded), `failed to decode value for key "foo"`)
+	require.Equal(t, map[string]int{"foo": 3, "bar": 2}, decoded.ToMap(), "failed decode should leave the tree untouched")
+
+	sum := NewWithOptions(WithOnConflict(func(old, new int) int { return old + new }))
+	require.NoError(t, json.Unmarshal([]byte(`{"a": 1, "a": 2}`), sum))
+	require.Equal(t, map[string]int{"a": 2}, sum.ToMap(), "the last value should win despite onConflict")
+}
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+
+	hashVal := func(val string) []byte { return []byte(val) }
+	m := map[string]string{
+		"":                "empty-val",
+		"namespace":       "namespace-val",
+		"namespace/pod-1": "pod-1-val",
+		"namespaces":      "namespaces-val",
+	}
+	tree := NewFromMap(m)
+	fingerprint := tree.Fingerprint(hashVal)
+
+	// Insertion history doesn't matter.
+	reversed := New[string]()
+	for _, key := range []string{"namespaces", "namespace/pod-1", "other", "namespace", ""} {
+		_, _, reversed = reversed.Insert([]byte(key), m[key])
+	}
+	reversed = mustDelete(t, reversed, "other")
+	require.Equal(t, fingerprint, reversed.Fingerprint(hashVal))
+
+	// Any change of keys or values does.
+	for name, changed := range map[string]*Iradix[string]{
+		"Changed value": mustInsert(t, tree, "namespace", "other-val"),
+		"Added key":     mustInsert(t, tree, "other", "other-val"),
+		"Deleted key":   mustDelete(t, tree, ""),
+		"Moved boundary": NewFromMap(map[string]string{
+			"":                "empty-val",
+			"namespace":       "namespace-val",
+			"namespace/pod-1": "pod-1-val",
+			"namespacesn":     "amespaces-val",
+		}),
+	} {
+		require.NotEqual(t, fingerprint, changed.Fingerprint(hashVal), name)
+	}
+
+	require.Equal(t, New[string]().Fingerprint(hashVal), New[string]().Fingerprint(hashVal))
+}
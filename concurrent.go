@@ -0,0 +1,63 @@
+package iradix
+
+import "sync/atomic"
+
+// ConcurrentIradix is a mutable handle to a tree that can be shared between
+// goroutines. Mutations are applied to the current tree and atomically swap
+// it for the result, retrying if another goroutine swapped it first. Readers
+// never block.
+type ConcurrentIradix[T any] struct {
+	tree atomic.Pointer[Iradix[T]]
+}
+
+// NewConcurrent returns a handle whose current tree is tree.
+func NewConcurrent[T any](tree *Iradix[T]) *ConcurrentIradix[T] {
+	c := &ConcurrentIradix[T]{}
+	c.tree.Store(tree)
+	return c
+}
+
+// Get returns the value stored under key in the current tree and whether it
+// was present.
+func (c *ConcurrentIradix[T]) Get(key []byte) (T, bool) {
+	return c.tree.Load().Get(key)
+}
+
+// Insert stores val under key and returns the previously stored value, if
+// any.
+func (c *ConcurrentIradix[T]) Insert(key []byte, val T) (oldVal T, existed bool) {
+	c.update(func(tree *Iradix[T]) *Iradix[T] {
+		var newTree *Iradix[T]
+		oldVal, existed, newTree = tree.Insert(key, val)
+		return newTree
+	})
+	return oldVal, existed
+}
+
+// Delete removes key and returns its value, if any.
+func (c *ConcurrentIradix[T]) Delete(key []byte) (oldVal T, existed bool) {
+	c.update(func(tree *Iradix[T]) *Iradix[T] {
+		var newTree *Iradix[T]
+		oldVal, existed, newTree = tree.Delete(key)
+		return newTree
+	})
+	return oldVal, existed
+}
+
+// Snapshot returns the current tree. It is immutable and thus unaffected by
+// subsequent mutations of c.
+func (c *ConcurrentIradix[T]) Snapshot() *Iradix[T] {
+	return c.tree.Load()
+}
+
+// update replaces the current tree with the result of f, calling f again
+// with the new current tree if it was swapped concurrently.
+func (c *ConcurrentIradix[T]) update(f func(*Iradix[T]) *Iradix[T]) {
+	for {
+		tree := c.tree.Load()
+		newTree := f(tree)
+		if newTree == tree || c.tree.CompareAndSwap(tree, newTree) {
+			return
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package iradix
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// config holds the settings of a tree. It is shared by all trees derived
+// from the same constructor call and never modified, apart from the counters
+// in stats. A nil config means defaults everywhere.
+type config[T any] struct {
+	// eq is used to detect inserts of a value identical to the stored one,
+	// which then return the unchanged tree. Defaults to reflect.DeepEqual.
+	eq func(a, b T) bool
+	// valueSize estimates the memory used by a value, see ApproxSizeBytes.
+	valueSize func(T) int
+	// keyArena makes batch txns allocate node paths from shared chunks.
+	keyArena bool
+	// onConflict computes the value to store when inserting a key that is
+	// already present. Defaults to the inserted value.
+	onConflict func(old, new T) T
+	// foldCase makes key lookups match ASCII letters case-insensitively.
+	foldCase bool
+	// stats counts the node operations of all txns, see Stats.
+	stats *treeStats
+	// copyVal deep-copies values passed into and returned from the tree.
+	copyVal func(T) T
+	// intern returns the canonical pointer for values equal to val.
+	intern func(val T) *T
+	// maxKeyLen limits the length of written keys if positive.
+	maxKeyLen int
+	// defaultVal is returned by GetOrDefault for absent keys if set.
+	defaultVal *T
+}
+
+// ErrKeyTooLong is returned for keys exceeding the limit of a tree configured
+// with WithMaxKeyLen.
+var ErrKeyTooLong = errors.New("key too long")
+
+// Option configures a tree created with NewWithOptions. Options can be
+// combined freely; if the same option is passed more than once, the last one
+// wins.
+type Option[T any] func(*config[T])
+
+// NewWithOptions returns an empty tree configured with opts.
+func NewWithOptions[T any](opts ...Option[T]) *Iradix[T] {
+	tree := New[T]()
+	if len(opts) > 0 {
+		tree.cfg = &config[T]{}
+		for _, opt := range opts {
+			opt(tree.cfg)
+		}
+	}
+	return tree
+}
+
+// WithComparable makes the tree compare values with == rather than
+// reflect.DeepEqual when checking whether an insert changes anything.
+func WithComparable[T comparable]() Option[T] {
+	return func(c *config[T]) {
+		c.eq = func(a, b T) bool { return a == b }
+	}
+}
+
+// WithValueSizer makes ApproxSizeBytes use sizeVal to estimate the memory
+// used by each value. This is useful for values that reference memory, like
+// strings, slices or maps.
+func WithValueSizer[T any](sizeVal func(T) int) Option[T] {
+	return func(c *config[T]) {
+		c.valueSize = sizeVal
+	}
+}
+
+// WithKeyArena makes batch operations like InsertMany allocate the paths of
+// new nodes from shared 4KiB chunks rather than individually. For many small
+// keys this saves about a quarter of the allocations, see
+// BenchmarkInsertMany. The downside is that a chunk is only freed once no
+// node references any part of it anymore, so deleting keys frees less
+// memory.
+func WithKeyArena[T any]() Option[T] {
+	return func(c *config[T]) {
+		c.keyArena = true
+	}
+}
+
+// WithOnConflict makes inserting a key that is already present, like with
+// Insert or InsertMany, store f(old, new) rather than new. If the result
+// equals old, the tree is returned unchanged.
+func WithOnConflict[T any](f func(old, new T) T) Option[T] {
+	return func(c *config[T]) {
+		c.onConflict = f
+	}
+}
+
+// WithCaseFold makes Get, Insert, Delete and the operations built on them
+// match keys case-insensitively. Only the ASCII letters A-Z and a-z are
+// folded, all other bytes must match exactly. Keys that only differ in case
+// refer to the same entry, so inserting "FOO" into a tree holding "foo"
+// overwrites its value. Stored keys keep the casing they were first written
+// with: After inserting "foo" and "FOObar", the keys are "foo" and "foobar".
+// Ordered and prefix operations like Iterate or SubTree compare the stored
+// keys byte by byte.
+func WithCaseFold[T any]() Option[T] {
+	return func(c *config[T]) {
+		c.foldCase = true
+	}
+}
+
+// WithStats makes the tree count how many nodes its mutations copy and
+// allocate and how often they split or merge nodes. The counters are shared
+// by all trees derived from it and can be read with Stats. Counting costs a
+// few atomic additions per mutation.
+func WithStats[T any]() Option[T] {
+	return func(c *config[T]) {
+		c.stats = &treeStats{}
+	}
+}
+
+// WithDeepCopy makes the tree store a copy made by copyVal of every value
+// written to it, including the results of Map, and hand out copies from all
+// methods returning a single value: Get, GetOrDefault, GetWithKey, GetRef,
+// GetMany, GetWatch, Nearest, At, Floor, Ceil, Predecessor and Successor, the
+// values loaded by GetOrInsert and GetOrInsertFunc, and the old values
+// returned by Insert, InsertChanged, Replace and Delete. This protects values
+// that reference mutable memory, like slices or maps, from being modified
+// through the tree or the caller's reference and thereby changing every
+// snapshot sharing them. Values yielded by iteration or passed to callbacks
+// are not copied and must still not be modified.
+//
+// Every write and read pays for a call to copyVal, which for slices and maps
+// means an allocation and a copy of their content. Only use this if callers
+// can't be trusted to leave values alone.
+func WithDeepCopy[T any](copyVal func(T) T) Option[T] {
+	return func(c *config[T]) {
+		c.copyVal = copyVal
+	}
+}
+
+// WithIntern makes all nodes holding equal values share a single copy of
+// them rather than each holding its own. This saves memory if many keys map
+// to few distinct values, like enum-like strings, at the cost of a map lookup
+// under a mutex per write. The canonical copies are shared by all trees
+// derived from the new one and never released, so the set of distinct values
+// should be small. Stored values are never modified in place, so sharing them
+// doesn't affect other keys or trees. Combined with WithDeepCopy, values are
+// copied before they are interned.
+func WithIntern[T comparable]() Option[T] {
+	return func(c *config[T]) {
+		var lock sync.Mutex
+		canonical := map[T]*T{}
+		c.intern = func(val T) *T {
+			lock.Lock()
+			defer lock.Unlock()
+			if ptr, ok := canonical[val]; ok {
+				return ptr
+			}
+			canonical[val] = &val
+			return &val
+		}
+	}
+}
+
+// WithMaxKeyLen makes the tree reject keys longer than maxLen bytes, which
+// protects servers from pathological keys. TryInsert returns an error for
+// such keys, while Insert and all other operations writing keys panic, as
+// they have no way to report it. Decoding with GobDecode or UnmarshalJSON
+// returns an error. A maxLen of zero or less disables the limit.
+func WithMaxKeyLen[T any](maxLen int) Option[T] {
+	return func(c *config[T]) {
+		c.maxKeyLen = maxLen
+	}
+}
+
+// WithDefault makes GetOrDefault return def for absent keys. This is meant
+// for values whose zero value isn't a safe fallback.
+func WithDefault[T any](def T) Option[T] {
+	return func(c *config[T]) {
+		c.defaultVal = &def
+	}
+}
+
+func (c *config[T]) equal(a, b T) bool {
+	if c == nil || c.eq == nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return c.eq(a, b)
+}
+
+func (c *config[T]) sizeOf(val T) int {
+	if c == nil || c.valueSize == nil {
+		return int(unsafe.Sizeof(val))
+	}
+	return c.valueSize(val)
+}
+
+func (c *config[T]) useKeyArena() bool {
+	return c != nil && c.keyArena
+}
+
+// treeStats returns the counters of the tree or nil if it doesn't count.
+func (c *config[T]) treeStats() *treeStats {
+	if c == nil {
+		return nil
+	}
+	return c.stats
+}
+
+// defaultValue returns the value to use for absent keys.
+func (c *config[T]) defaultValue() T {
+	if c == nil || c.defaultVal == nil {
+		return *new(T)
+	}
+	return *c.defaultVal
+}
+
+func (c *config[T]) copyValue(val T) T {
+	if c == nil || c.copyVal == nil {
+		return val
+	}
+	return c.copyVal(val)
+}
+
+// storeValue returns the pointer to store in a node that is to hold val.
+func (c *config[T]) storeValue(val T) *T {
+	val = c.copyValue(val)
+	if c == nil || c.intern == nil {
+		return &val
+	}
+	return c.intern(val)
+}
+
+// checkKeyLen returns an error wrapping ErrKeyTooLong if key exceeds the
+// maximum key length.
+func (c *config[T]) checkKeyLen(key []byte) error {
+	if c != nil && c.maxKeyLen > 0 && len(key) > c.maxKeyLen {
+		return fmt.Errorf("%w: length %d exceeds the maximum of %d", ErrKeyTooLong, len(key), c.maxKeyLen)
+	}
+	return nil
+}
+
+func (c *config[T]) resolveConflict(old, new T) T {
+	if c == nil || c.onConflict == nil {
+		return new
+	}
+	return c.onConflict(old, new)
+}
+
+// foldsCase reports whether key lookups ignore ASCII case. Lookups check it
+// once and only fall back to the fold helpers below if the plain comparison
+// fails, so that trees without case folding keep the fast path.
+func (c *config[T]) foldsCase() bool {
+	return c != nil && c.foldCase
+}
+
+// findToggledChild returns the index of the child whose first byte is
+// firstByte with its ASCII case toggled, or -1 if there is none.
+func findToggledChild[T any](children []*node[T], firstByte byte) int {
+	other := toggleASCIICase(firstByte)
+	if other == firstByte {
+		return -1
+	}
+	return findChild(children, other)
+}
+
+// commonPrefixLenFold is like commonPrefixLen, but ignores ASCII case.
+func commonPrefixLenFold(a, b []byte) int {
+	maxLen := min(len(a), len(b))
+	for i := range maxLen {
+		if lowerASCII(a[i]) != lowerASCII(b[i]) {
+			return i
+		}
+	}
+	return maxLen
+}
+
+// hasPrefixFold is like bytes.HasPrefix, but ignores ASCII case.
+func hasPrefixFold(s, prefix []byte) bool {
+	return len(s) >= len(prefix) && commonPrefixLenFold(s, prefix) == len(prefix)
+}
+
+func lowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+func toggleASCIICase(b byte) byte {
+	switch {
+	case 'A' <= b && b <= 'Z':
+		return b + 'a' - 'A'
+	case 'a' <= b && b <= 'z':
+		return b - ('a' - 'A')
+	}
+	return b
+}
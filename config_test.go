@@ -0,0 +1,482 @@
+package iradix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	t.Parallel()
+
+	require.Nil(t, NewWithOptions[string]().cfg, "a tree without options should use the defaults")
+
+	opts := []Option[string]{
+		WithCaseFold[string](),
+		WithMaxKeyLen[string](8),
+		WithStats[string](),
+		WithDefault("default-val"),
+	}
+	tree := NewWithOptions(opts...)
+	tree = mustInsert(t, tree, "Pod-1", "pod-1-val")
+
+	_, _, sameTree, err := tree.TryInsert([]byte("123456789"), "too-long-val")
+	require.ErrorIs(t, err, ErrKeyTooLong)
+	require.Same(t, tree, sameTree)
+
+	val, ok := tree.Get([]byte("POD-1"))
+	require.True(t, ok)
+	require.Equal(t, "pod-1-val", val)
+	require.Equal(t, "default-val", tree.GetOrDefault([]byte("pod-2")))
+	require.Equal(t, Stats{NodesCopied: 1, NodesAllocated: 1}, tree.Stats())
+
+	// Reusing the options creates a tree with its own counters.
+	other := mustInsert(t, NewWithOptions(opts...), "pod-1", "pod-1-val")
+	require.Equal(t, Stats{NodesCopied: 1, NodesAllocated: 1}, other.Stats())
+	require.Equal(t, Stats{NodesCopied: 1, NodesAllocated: 1}, tree.Stats())
+
+	// The last of repeated options wins.
+	limited := NewWithOptions(WithMaxKeyLen[string](8), WithMaxKeyLen[string](4))
+	_, _, _, err = limited.TryInsert([]byte("pod-1"), "pod-1-val")
+	require.ErrorIs(t, err, ErrKeyTooLong)
+}
+
+func TestWithComparable(t *testing.T) {
+	t.Parallel()
+
+	type value struct {
+		name  string
+		count int
+	}
+
+	tree := NewWithOptions(WithComparable[value]())
+	_, _, tree = tree.Insert([]byte("foo"), value{name: "foo", count: 1})
+
+	_, existed, sameTree := tree.Insert([]byte("foo"), value{name: "foo", count: 1})
+	require.True(t, existed)
+	require.Same(t, tree, sameTree, "inserting an identical value should not change the tree")
+
+	oldVal, existed, newTree := tree.Insert([]byte("foo"), value{name: "foo", count: 2})
+	require.True(t, existed)
+	require.Equal(t, value{name: "foo", count: 1}, oldVal)
+	require.NotSame(t, tree, newTree)
+
+	// Derived trees keep the comparator
+	for _, derived := range []*Iradix[value]{
+		newTree.InsertMany(func(yield func([]byte, value) bool) { yield([]byte("bar"), value{}) }),
+		newTree.Map(func(_ []byte, val value) value { return val }),
+		newTree.Filter(func([]byte, value) bool { return true }),
+		mustDelete(t, newTree, "foo"),
+		newTree.Clear(),
+	} {
+		require.Same(t, newTree.cfg, derived.cfg)
+	}
+}
+
+func TestClear(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{"": "empty-val", "foo": "foo-val"})
+	cleared := tree.Clear()
+	validateTree(t, cleared)
+	require.True(t, cleared.IsEmpty())
+	require.Equal(t, 0, cleared.Len())
+	require.Equal(t, 2, tree.Len(), "original tree should be unmodified")
+
+	comparable := NewWithOptions(WithComparable[int]())
+	_, _, comparable = comparable.Insert([]byte("foo"), 1)
+	require.Same(t, comparable.cfg, comparable.Clear().cfg)
+}
+
+func TestWithOnConflict(t *testing.T) {
+	t.Parallel()
+
+	tree := NewWithOptions(WithOnConflict(func(old, new []string) []string {
+		return append(slices.Clone(old), new...)
+	}))
+	_, _, tree = tree.Insert([]byte("foo"), []string{"a"})
+	oldVal, existed, tree := tree.Insert([]byte("foo"), []string{"b"})
+	require.True(t, existed)
+	require.Equal(t, []string{"a"}, oldVal)
+
+	tree = tree.InsertMany(func(yield func([]byte, []string) bool) {
+		for _, val := range []string{"c", "d"} {
+			if !yield([]byte("foo"), []string{val}) {
+				return
+			}
+		}
+	})
+	validateTree(t, tree)
+	require.Equal(t, map[string][]string{"foo": {"a", "b", "c", "d"}}, tree.ToMap())
+
+	// Rejecting the overwrite keeps the tree as-is.
+	keepOld := NewWithOptions(WithOnConflict(func(old, _ int) int { return old }))
+	_, _, keepOld = keepOld.Insert([]byte("foo"), 1)
+	_, existed, sameTree := keepOld.Insert([]byte("foo"), 2)
+	require.True(t, existed)
+	require.Same(t, keepOld, sameTree)
+
+	// The hook isn't called for new keys and the default is last write wins.
+	_, _, keepOld = keepOld.Insert([]byte("bar"), 3)
+	require.Equal(t, map[string]int{"foo": 1, "bar": 3}, keepOld.ToMap())
+	_, _, lastWriteWins := NewFromMap(map[string]int{"foo": 1}).Insert([]byte("foo"), 2)
+	require.Equal(t, map[string]int{"foo": 2}, lastWriteWins.ToMap())
+}
+
+func TestWithCaseFold(t *testing.T) {
+	t.Parallel()
+
+	tree := NewWithOptions(WithCaseFold[string]())
+	_, _, tree = tree.Insert([]byte("foo"), "foo-val")
+	_, _, tree = tree.Insert([]byte("Example.COM"), "example-val")
+	validateTree(t, tree)
+
+	for _, key := range []string{"foo", "FOO", "fOo", "example.com", "EXAMPLE.COM"} {
+		val, found := tree.Get([]byte(key))
+		require.True(t, found, "key %q", key)
+		require.Equal(t, strings.ToLower(key) == "foo", val == "foo-val", "key %q", key)
+	}
+	for _, key := range []string{"fo", "foob", "example_com", "fo\xcf"} {
+		_, found := tree.Get([]byte(key))
+		require.False(t, found, "key %q", key)
+	}
+
+	// Lookups built on Get fold case as well.
+	storedKey, val, found := tree.GetWithKey([]byte("EXAMPLE.com"))
+	require.True(t, found)
+	require.Equal(t, "example-val", val)
+	require.Equal(t, "Example.COM", string(storedKey))
+	require.Equal(t, []Result[string]{
+		{Val: "foo-val", Found: true},
+		{Val: "example-val", Found: true},
+		{},
+	}, tree.GetMany([][]byte{[]byte("FOO"), []byte("example.com"), []byte("FOOB")}))
+	watchCh, val, found := tree.GetWatch([]byte("FOO"))
+	require.True(t, found)
+	require.Equal(t, "foo-val", val)
+	matchedLen, val, found := tree.LongestPrefixLen([]byte("FOOBAR"))
+	require.True(t, found)
+	require.Equal(t, 3, matchedLen)
+	require.Equal(t, "foo-val", val)
+	require.Equal(t, [][]byte{[]byte("Foo")}, tree.MatchingPrefixes([]byte("Foo.bar")))
+
+	// Keys differing in case collide and keep the first-written casing.
+	oldVal, existed, tree := tree.Insert([]byte("FOO"), "other-val")
+	require.True(t, existed)
+	require.Equal(t, "foo-val", oldVal)
+	require.True(t, isClosed(watchCh))
+	_, _, tree = tree.Insert([]byte("FOObar"), "foobar-val")
+	_, _, tree = tree.Insert([]byte("EXAMPLE.net"), "net-val")
+	validateTree(t, tree)
+	require.Equal(t, map[string]string{
+		"Example.COM": "example-val",
+		"Example.net": "net-val",
+		"foo":         "other-val",
+		"foobar":      "foobar-val",
+	}, tree.ToMap())
+
+	oldVal, existed, tree = tree.Delete([]byte("EXAMPLE.com"))
+	require.True(t, existed)
+	require.Equal(t, "example-val", oldVal)
+	_, existed, _ = tree.Delete([]byte("example.org"))
+	require.False(t, existed)
+	validateTree(t, tree)
+	require.Equal(t, 3, tree.Len())
+
+	// Without the option, case matters.
+	_, found = NewFromMap(map[string]string{"foo": "foo-val"}).Get([]byte("FOO"))
+	require.False(t, found)
+}
+
+func TestWithKeyArena(t *testing.T) {
+	t.Parallel()
+
+	m := map[string]int{}
+	for idx := range 1000 {
+		m[fmt.Sprintf("namespace/pod-%d", idx)] = idx
+	}
+	keys := slices.Sorted(maps.Keys(m))
+
+	tree := NewWithOptions(WithKeyArena[int]())
+	tree = tree.InsertMany(func(yield func([]byte, int) bool) {
+		for _, key := range slices.Backward(keys) {
+			if !yield([]byte(key), m[key]) {
+				return
+			}
+		}
+	})
+	validateTree(t, tree)
+	require.Equal(t, m, tree.ToMap())
+	snapshot := snapshotNodes(tree)
+
+	// Mutating trees sharing arena chunks must not affect each other
+	deleted, _ := tree.DeleteFunc(func(_ []byte, val int) bool { return val%3 != 0 })
+	validateTree(t, deleted)
+	inserted := deleted.InsertMany(func(yield func([]byte, int) bool) {
+		for _, key := range keys {
+			if !yield([]byte(key+"/container"), 0) {
+				return
+			}
+		}
+	})
+	validateTree(t, inserted)
+	requireNodesUnchanged(t, snapshot)
+	require.Equal(t, m, tree.ToMap())
+	require.Equal(t, len(keys)/3+1+len(keys), inserted.Len())
+}
+
+func TestWithStats(t *testing.T) {
+	t.Parallel()
+
+	tree := NewWithOptions(WithStats[string]())
+	require.Equal(t, Stats{}, tree.Stats())
+
+	tree = mustInsert(t, tree, "foo", "foo-val")
+	require.Equal(t, Stats{NodesCopied: 1, NodesAllocated: 1}, tree.Stats())
+
+	tree = mustInsert(t, tree, "foobar", "foobar-val")
+	require.Equal(t, Stats{NodesCopied: 3, NodesAllocated: 2}, tree.Stats())
+
+	// Splits "foo" into "fo" with the children "o" and "x".
+	withFox := mustInsert(t, tree, "fox", "fox-val")
+	require.Equal(t, Stats{NodesCopied: 5, NodesAllocated: 4, Splits: 1}, withFox.Stats())
+
+	// Merges "fo" back with its only remaining child.
+	withoutFox := mustDelete(t, withFox, "fox")
+	validateTree(t, withoutFox)
+	require.Equal(t, Stats{NodesCopied: 9, NodesAllocated: 4, Splits: 1, Merges: 1}, withoutFox.Stats())
+
+	// The counters are shared with all derived trees, including older ones.
+	require.Equal(t, withoutFox.Stats(), tree.Stats())
+
+	// A batch copies every node at most once. The second key splits the
+	// path "/0" of the first one.
+	before := tree.Stats()
+	tree = tree.InsertMany(func(yield func([]byte, string) bool) {
+		for i := range 10 {
+			if !yield([]byte(fmt.Sprintf("foobar/%d", i)), "val") {
+				return
+			}
+		}
+	})
+	validateTree(t, tree)
+	after := tree.Stats()
+	require.Equal(t, uint64(3), after.NodesCopied-before.NodesCopied)
+	require.Equal(t, uint64(11), after.NodesAllocated-before.NodesAllocated)
+	require.Equal(t, uint64(1), after.Splits-before.Splits)
+
+	require.Equal(t, Stats{}, mustInsert(t, New[string](), "foo", "foo-val").Stats())
+}
+
+func TestWithDeepCopy(t *testing.T) {
+	t.Parallel()
+
+	tree := NewWithOptions(WithDeepCopy(slices.Clone[[]int]))
+
+	// Modifying the inserted slice doesn't affect the tree.
+	inserted := []int{1, 2}
+	_, _, tree = tree.Insert([]byte("foo"), inserted)
+	inserted[0] = 100
+	val, ok := tree.Get([]byte("foo"))
+	require.True(t, ok)
+	require.Equal(t, []int{1, 2}, val)
+
+	// Neither does modifying returned ones.
+	key := []byte("foo")
+	for name, get := range map[string]func() []int{
+		"Get":          func() []int { val, _ := tree.Get(key); return val },
+		"GetOrDefault": func() []int { return tree.GetOrDefault(key) },
+		"GetWithKey":   func() []int { _, val, _ := tree.GetWithKey(key); return val },
+		"GetRef":       func() []int { ref, _ := tree.GetRef(key); return *ref },
+		"GetMany":      func() []int { return tree.GetMany([][]byte{key})[0].Val },
+		"GetWatch":     func() []int { _, val, _ := tree.GetWatch(key); return val },
+		"Nearest":      func() []int { _, val, _ := tree.Nearest(key); return val },
+		"At":           func() []int { _, val, _ := tree.At(0); return val },
+		"Floor":        func() []int { _, val, _ := tree.Floor(key); return val },
+		"Ceil":         func() []int { _, val, _ := tree.Ceil(key); return val },
+		"Predecessor":  func() []int { _, val, _ := tree.Predecessor([]byte("fop")); return val },
+		"Successor":    func() []int { _, val, _ := tree.Successor([]byte("fo")); return val },
+		"GetOrInsert":  func() []int { val, _, _ := tree.GetOrInsert(key, nil); return val },
+		"Insert":       func() []int { oldVal, _, _ := tree.Insert(key, []int{3}); return oldVal },
+		"InsertChanged": func() []int {
+			oldVal, _, _ := tree.InsertChanged(key, []int{3}, slices.Equal[[]int])
+			return oldVal
+		},
+		"Replace": func() []int { oldVal, _, _ := tree.Replace(key, []int{3}); return oldVal },
+		"Delete":  func() []int { oldVal, _, _ := tree.Delete(key); return oldVal },
+	} {
+		val := get()
+		require.Equal(t, []int{1, 2}, val, name)
+		val[0] = 100
+		require.Equal(t, map[string][]int{"foo": {1, 2}}, tree.ToMap(), name)
+	}
+
+	// All writes store copies.
+	written := []int{3}
+	_, _, updated := tree.InsertChanged(key, written, slices.Equal[[]int])
+	written[0] = 100
+	updatedVal, updated := updated.Update(key, func(old []int, _ bool) []int { return append(old, 4) })
+	updatedVal[0] = 100
+	require.Equal(t, map[string][]int{"foo": {3, 4}}, updated.ToMap())
+	mapped := []int{5}
+	updated = updated.Map(func([]byte, []int) []int { return mapped })
+	mapped[0] = 100
+	require.Equal(t, map[string][]int{"foo": {5}}, updated.ToMap())
+	validateTree(t, updated)
+
+	require.Equal(t, map[string][]int{"foo": {1, 2}}, tree.ToMap())
+}
+
+func TestWithIntern(t *testing.T) {
+	t.Parallel()
+
+	tree := NewWithOptions(WithIntern[string]())
+	_, _, tree = tree.Insert([]byte("namespace/pod-1"), "running")
+	tree = tree.InsertMany(func(yield func([]byte, string) bool) {
+		_ = yield([]byte("namespace/pod-2"), "running") &&
+			yield([]byte("namespace/pod-3"), "pending") &&
+			yield([]byte("namespace/pod"), "running")
+	})
+	validateTree(t, tree)
+
+	ref := func(tree *Iradix[string], key string) *string {
+		ref, ok := tree.GetRef([]byte(key))
+		require.True(t, ok, "key %q", key)
+		return ref
+	}
+	running := ref(tree, "namespace/pod-1")
+	require.Same(t, running, ref(tree, "namespace/pod-2"))
+	require.Same(t, running, ref(tree, "namespace/pod"))
+	require.NotSame(t, running, ref(tree, "namespace/pod-3"))
+
+	// Changing the value of one key doesn't affect the others.
+	_, _, updated := tree.Insert([]byte("namespace/pod-1"), "pending")
+	require.Same(t, ref(tree, "namespace/pod-3"), ref(updated, "namespace/pod-1"))
+	require.Equal(t, map[string]string{
+		"namespace/pod":   "running",
+		"namespace/pod-1": "pending",
+		"namespace/pod-2": "running",
+		"namespace/pod-3": "pending",
+	}, updated.ToMap())
+	require.Equal(t, "running", *running)
+
+	// Map interns its results as well.
+	mapped := tree.Map(func([]byte, string) string { return "running" })
+	require.Same(t, running, ref(mapped, "namespace/pod-3"))
+
+	// Derived trees share the canonical values, also when mutated
+	// concurrently.
+	var wg sync.WaitGroup
+	refs := make([]*string, 10)
+	for idx := range refs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, derived := tree.Insert([]byte(fmt.Sprintf("other/pod-%d", idx)), "succeeded")
+			refs[idx], _ = derived.GetRef([]byte(fmt.Sprintf("other/pod-%d", idx)))
+		}()
+	}
+	wg.Wait()
+	for _, other := range refs[1:] {
+		require.Same(t, refs[0], other)
+	}
+}
+
+func TestWithMaxKeyLen(t *testing.T) {
+	t.Parallel()
+
+	tree := NewWithOptions(WithMaxKeyLen[string](8))
+	_, _, tree, err := tree.TryInsert([]byte("pod-1"), "pod-1-val")
+	require.NoError(t, err)
+	_, _, tree, err = tree.TryInsert([]byte("12345678"), "max-val")
+	require.NoError(t, err)
+
+	_, _, sameTree, err := tree.TryInsert([]byte("123456789"), "too-long-val")
+	require.ErrorIs(t, err, ErrKeyTooLong)
+	require.EqualError(t, err, "key too long: length 9 exceeds the maximum of 8")
+	require.Same(t, tree, sameTree)
+
+	// Operations without error return panic.
+	tooLong := []byte("123456789")
+	for name, write := range map[string]func(){
+		"Insert":      func() { tree.Insert(tooLong, "val") },
+		"GetOrInsert": func() { tree.GetOrInsert(tooLong, "val") },
+		"Update":      func() { tree.Update(tooLong, func(string, bool) string { return "val" }) },
+		"InsertMany": func() {
+			tree.InsertMany(func(yield func([]byte, string) bool) {
+				_ = yield([]byte("pod-2"), "val") && yield(tooLong, "val")
+			})
+		},
+		"ReplacePrefix": func() { tree.ReplacePrefix([]byte("pod-"), []byte("pod-xxxx")) },
+	} {
+		require.PanicsWithError(t, "key too long: length 9 exceeds the maximum of 8", write, name)
+	}
+	validateTree(t, tree)
+	require.Equal(t, map[string]string{"pod-1": "pod-1-val", "12345678": "max-val"}, tree.ToMap())
+
+	// Decoding returns an error.
+	data, err := json.Marshal(map[string]string{"123456789": "val"})
+	require.NoError(t, err)
+	require.ErrorIs(t, NewWithOptions(WithMaxKeyLen[string](8)).UnmarshalJSON(data), ErrKeyTooLong)
+	data, err = NewFromMap(map[string]string{"123456789": "val"}).GobEncode()
+	require.NoError(t, err)
+	require.ErrorIs(t, NewWithOptions(WithMaxKeyLen[string](8)).GobDecode(data), ErrKeyTooLong)
+
+	// Without the option, keys of any length are accepted.
+	_, _, _, err = New[string]().TryInsert(bytes.Repeat([]byte("x"), 1<<16), "val")
+	require.NoError(t, err)
+}
+
+func TestWithDefault(t *testing.T) {
+	t.Parallel()
+
+	tree := NewWithOptions(WithDefault(-1))
+	require.Equal(t, -1, tree.GetOrDefault([]byte("replicas")))
+
+	tree = mustInsert(t, tree, "replicas", 0)
+	tree = mustInsert(t, tree, "replicas/max", 5)
+	require.Equal(t, 0, tree.GetOrDefault([]byte("replicas")))
+	require.Equal(t, 5, tree.GetOrDefault([]byte("replicas/max")))
+	require.Equal(t, -1, tree.GetOrDefault([]byte("replicas/min")))
+
+	// Get is unaffected.
+	val, ok := tree.Get([]byte("replicas/min"))
+	require.False(t, ok)
+	require.Zero(t, val)
+
+	// Derived trees keep the default.
+	_, _, deleted := tree.Delete([]byte("replicas"))
+	require.Equal(t, -1, deleted.GetOrDefault([]byte("replicas")))
+	require.Equal(t, -1, tree.Clear().GetOrDefault([]byte("replicas/max")))
+
+	require.Zero(t, New[int]().GetOrDefault([]byte("replicas")))
+}
+
+func BenchmarkInsertIdentical(b *testing.B) {
+	type value struct {
+		name  string
+		count int
+	}
+	for name, tree := range map[string]*Iradix[value]{
+		"DeepEqual":  New[value](),
+		"Comparable": NewWithOptions(WithComparable[value]()),
+	} {
+		for i := range 100 {
+			_, _, tree = tree.Insert([]byte(fmt.Sprintf("prefix/%d", i)), value{name: "val", count: i})
+		}
+
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tree.Insert([]byte("prefix/42"), value{name: "val", count: 42})
+			}
+		})
+	}
+}
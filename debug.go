@@ -0,0 +1,229 @@
+package iradix
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// String renders the tree structure for debugging, with one node per line
+// showing its quoted path and its value if it has one.
+func (i *Iradix[T]) String() string {
+	sb := &strings.Builder{}
+	var render func(n *node[T], indent, childIndent string)
+	render = func(n *node[T], indent, childIndent string) {
+		sb.WriteString(indent)
+		fmt.Fprintf(sb, "%q", n.path)
+		if n.val != nil {
+			fmt.Fprintf(sb, " = %v", *n.val)
+		}
+		sb.WriteString("\n")
+
+		for idx, child := range n.children {
+			if idx == len(n.children)-1 {
+				render(child, childIndent+"└── ", childIndent+"    ")
+			} else {
+				render(child, childIndent+"├── ", childIndent+"│   ")
+			}
+		}
+	}
+	render(i.root, "", "")
+
+	return sb.String()
+}
+
+// Canonical renders the entries for golden tests, with one line per entry
+// in key order consisting of the key, a tab and the value formatted with %v.
+// Unlike String, it only depends on the entries, not on the structure of the
+// tree. Keys and values are written as-is, so they should not contain tabs
+// or newlines.
+func (i *Iradix[T]) Canonical() string {
+	sb := &strings.Builder{}
+	for key, val := range i.Iterate() {
+		fmt.Fprintf(sb, "%s\t%v\n", key, val)
+	}
+	return sb.String()
+}
+
+// WriteDOT writes the tree structure as a Graphviz digraph. Every node is
+// labeled with its quoted path, nodes that hold a value are drawn with a
+// double border and edges are labeled with the first byte of the child.
+func (i *Iradix[T]) WriteDOT(w io.Writer) error {
+	var err error
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("digraph iradix {\n")
+	nextID := 0
+	var write func(n *node[T]) int
+	write = func(n *node[T]) int {
+		id := nextID
+		nextID++
+
+		attrs := ""
+		if n.val != nil {
+			attrs = " peripheries=2"
+		}
+		printf("  n%d [label=%s%s];\n", id, strconv.Quote(strconv.Quote(string(n.path))), attrs)
+
+		for _, child := range n.children {
+			childID := write(child)
+			printf("  n%d -> n%d [label=%s];\n", id, childID, strconv.Quote(strconv.QuoteRune(rune(child.path[0]))))
+		}
+		return id
+	}
+	write(i.root)
+	printf("}\n")
+
+	return err
+}
+
+// Height returns the maximum number of nodes on a path from the root to a
+// leaf, including both. An empty tree consists of the root only and has a
+// height of one.
+func (i *Iradix[T]) Height() int {
+	var height func(n *node[T]) int
+	height = func(n *node[T]) int {
+		maxChildHeight := 0
+		for _, child := range n.children {
+			maxChildHeight = max(maxChildHeight, height(child))
+		}
+		return maxChildHeight + 1
+	}
+	return height(i.root)
+}
+
+// NumNodes returns the number of nodes in the tree, including the root and
+// nodes that only exist to split a compressed path.
+func (i *Iradix[T]) NumNodes() int {
+	return countNodes(i.root)
+}
+
+func countNodes[T any](n *node[T]) int {
+	count := 1
+	for _, child := range n.children {
+		count += countNodes(child)
+	}
+	return count
+}
+
+// NodePaths returns the key of every node in the same order as Walk visits
+// them, starting with the empty key of the root. Unlike Keys, it includes
+// nodes that only exist to split a compressed path. Every key is a fresh copy.
+func (i *Iradix[T]) NodePaths() [][]byte {
+	var paths [][]byte
+	i.Walk(func(key []byte, _ T, _ bool) bool {
+		paths = append(paths, slices.Clone(key))
+		return true
+	})
+	return paths
+}
+
+// SharedNodes returns how many of the nodes of b are also part of a, along
+// with the total number of nodes of b. It is meant to verify how much
+// structure a mutation shared with the tree it was applied to.
+func SharedNodes[T any](a, b *Iradix[T]) (shared, total int) {
+	nodesOfA := map[*node[T]]struct{}{}
+	var collect func(n *node[T])
+	collect = func(n *node[T]) {
+		nodesOfA[n] = struct{}{}
+		for _, child := range n.children {
+			collect(child)
+		}
+	}
+	collect(a.root)
+
+	var count func(n *node[T])
+	count = func(n *node[T]) {
+		if _, ok := nodesOfA[n]; ok {
+			// Nodes are immutable, so the whole subtree is shared.
+			size := countNodes(n)
+			shared += size
+			total += size
+			return
+		}
+		total++
+		for _, child := range n.children {
+			count(child)
+		}
+	}
+	count(b.root)
+
+	return shared, total
+}
+
+// Compact returns the tree in its canonical, maximally compressed form, in
+// which no node other than the root is without a value unless it has at
+// least two children. Since all mutations maintain that form, this is meant
+// as a baseline for debugging and returns i itself if it is compressed.
+func (i *Iradix[T]) Compact() *Iradix[T] {
+	var compact func(n *node[T], isRoot bool) *node[T]
+	compact = func(n *node[T], isRoot bool) *node[T] {
+		children := make([]*node[T], 0, len(n.children))
+		changed := false
+		for _, child := range n.children {
+			compacted := compact(child, false)
+			changed = changed || compacted != child
+			if compacted != nil {
+				children = append(children, compacted)
+			}
+		}
+
+		if !isRoot && n.val == nil {
+			switch len(children) {
+			case 0:
+				return nil
+			case 1:
+				return &node[T]{
+					path:        append(slices.Clone(n.path), children[0].path...),
+					val:         children[0].val,
+					children:    children[0].children,
+					subtreeSize: children[0].subtreeSize,
+				}
+			}
+		}
+
+		size := 0
+		if n.val != nil {
+			size++
+		}
+		for _, child := range children {
+			size += child.subtreeSize
+		}
+		if !changed && size == n.subtreeSize {
+			return n
+		}
+		return &node[T]{path: n.path, val: n.val, children: children, subtreeSize: size}
+	}
+
+	root := compact(i.root, true)
+	if root == i.root {
+		return i
+	}
+	return i.derive(root, i.len)
+}
+
+// ApproxSizeBytes estimates the memory used by the tree. It accounts for the
+// nodes, their paths and child slices and the values, whose size defaults to
+// the size of T and can be configured with WithValueSizer. Memory shared
+// with other trees is counted as well.
+func (i *Iradix[T]) ApproxSizeBytes() int {
+	var sizeOf func(n *node[T]) int
+	sizeOf = func(n *node[T]) int {
+		size := int(unsafe.Sizeof(*n)) + len(n.path) + cap(n.children)*int(unsafe.Sizeof(n))
+		if n.val != nil {
+			size += i.cfg.sizeOf(*n.val)
+		}
+		for _, child := range n.children {
+			size += sizeOf(child)
+		}
+		return size
+	}
+	return int(unsafe.Sizeof(*i)) + sizeOf(i.root)
+}
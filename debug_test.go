@@ -0,0 +1,260 @@
+package iradix
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestString(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	expected := `"" = empty-val
+└── "namespace" = namespace-val
+    ├── "/pod-"
+    │   ├── "1" = pod-1-val
+    │   └── "2/owner-"
+    │       ├── "1" = owner-1-val
+    │       └── "2" = owner-2-val
+    └── "s" = namespaces-val
+`
+	require.Equal(t, expected, tree.String())
+	require.Equal(t, "\"\"\n", New[string]().String())
+}
+
+func TestCanonical(t *testing.T) {
+	t.Parallel()
+
+	m := map[string]int{
+		"":                -1,
+		"namespace":       0,
+		"namespace/pod-1": 1,
+		"namespace/pod-2": 2,
+		"namespaces":      3,
+	}
+	tree := NewFromMap(m)
+
+	expected := "\t-1\n" +
+		"namespace\t0\n" +
+		"namespace/pod-1\t1\n" +
+		"namespace/pod-2\t2\n" +
+		"namespaces\t3\n"
+	require.Equal(t, expected, tree.Canonical())
+
+	// Insertion history doesn't matter.
+	reversed := New[int]()
+	for _, key := range []string{"namespaces", "namespace/pod-2", "other", "namespace/pod-1", "namespace", ""} {
+		_, _, reversed = reversed.Insert([]byte(key), m[key])
+	}
+	_, _, reversed = reversed.Delete([]byte("other"))
+	require.Equal(t, expected, reversed.Canonical())
+
+	type pod struct {
+		Name  string
+		Ports []int
+	}
+	_, _, pods := New[pod]().Insert([]byte("pod-1"), pod{Name: "pod-1", Ports: []int{80}})
+	require.Equal(t, "pod-1\t{pod-1 [80]}\n", pods.Canonical())
+	require.Empty(t, New[int]().Canonical())
+}
+
+func TestWriteDOT(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{
+		"":          "empty-val",
+		"namespace": "namespace-val",
+		"name/x":    "x-val",
+		"name/y":    "y-val",
+	})
+
+	expected := `digraph iradix {
+  n0 [label="\"\"" peripheries=2];
+  n1 [label="\"name\""];
+  n2 [label="\"/\""];
+  n3 [label="\"x\"" peripheries=2];
+  n2 -> n3 [label="'x'"];
+  n4 [label="\"y\"" peripheries=2];
+  n2 -> n4 [label="'y'"];
+  n1 -> n2 [label="'/'"];
+  n5 [label="\"space\"" peripheries=2];
+  n1 -> n5 [label="'s'"];
+  n0 -> n1 [label="'n'"];
+}
+`
+	sb := &strings.Builder{}
+	require.NoError(t, tree.WriteDOT(sb))
+	require.Equal(t, expected, sb.String())
+
+	require.Error(t, tree.WriteDOT(failingWriter{}))
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestHeightAndNumNodes(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		keys           []string
+		expectHeight   int
+		expectNumNodes int
+	}{
+		{
+			name:           "Empty tree",
+			expectHeight:   1,
+			expectNumNodes: 1,
+		},
+		{
+			name:           "Empty key only",
+			keys:           []string{""},
+			expectHeight:   1,
+			expectNumNodes: 1,
+		},
+		{
+			name:           "Single compressed key",
+			keys:           []string{"namespace/pod-1"},
+			expectHeight:   2,
+			expectNumNodes: 2,
+		},
+		{
+			name:           "Split nodes",
+			keys:           []string{"namespace", "namespace/pod-1", "namespace/pod-2/owner-1", "namespace/pod-2/owner-2", "namespaces"},
+			expectHeight:   5,
+			expectNumNodes: 8,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := map[string]string{}
+			for _, key := range tc.keys {
+				m[key] = key
+			}
+			tree := NewFromMap(m)
+			require.Equal(t, tc.expectHeight, tree.Height())
+			require.Equal(t, tc.expectNumNodes, tree.NumNodes())
+		})
+	}
+}
+
+func TestNodePaths(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, [][]byte{nil}, New[string]().NodePaths())
+
+	tree := newTestTree("")
+	var paths []string
+	for _, path := range tree.NodePaths() {
+		paths = append(paths, string(path))
+	}
+	require.Equal(t, []string{
+		"",
+		"namespace",
+		"namespace/pod-",
+		"namespace/pod-1",
+		"namespace/pod-2/owner-",
+		"namespace/pod-2/owner-1",
+		"namespace/pod-2/owner-2",
+		"namespaces",
+	}, paths)
+	require.Len(t, paths, tree.NumNodes())
+}
+
+func TestSharedNodes(t *testing.T) {
+	t.Parallel()
+
+	tree := New[int]().InsertMany(func(yield func([]byte, int) bool) {
+		for i := range 1000 {
+			if !yield([]byte(fmt.Sprintf("prefix%d/%d", i%10, i)), i) {
+				return
+			}
+		}
+	})
+
+	shared, total := SharedNodes(tree, tree)
+	require.Equal(t, tree.NumNodes(), shared)
+	require.Equal(t, tree.NumNodes(), total)
+
+	shared, total = SharedNodes(tree, tree.Map(func(_ []byte, val int) int { return val }))
+	require.Zero(t, shared)
+	require.Equal(t, tree.NumNodes(), total)
+
+	// A single insert only copies the nodes on the path to the new key.
+	_, _, newTree := tree.Insert([]byte("prefix3/new"), -1)
+	shared, total = SharedNodes(tree, newTree)
+	require.Equal(t, newTree.NumNodes(), total)
+	require.LessOrEqual(t, total-shared, tree.Height()+1)
+	require.Greater(t, shared, 0)
+}
+
+func TestCompact(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree("namespaces")
+	originalTreeDump := dumpTree(tree)
+	require.Same(t, tree, tree.Compact(), "compressed tree should be returned as-is")
+	require.Equal(t, originalTreeDump, dumpTree(tree))
+
+	val := "val"
+	uncompressed := &Iradix[string]{len: 2, root: &node[string]{children: []*node[string]{
+		{path: []byte("a"), children: []*node[string]{
+			{path: []byte("b"), children: []*node[string]{
+				{path: []byte("c"), val: &val},
+			}},
+		}},
+		{path: []byte("x"), children: []*node[string]{
+			{path: []byte("y")},
+			{path: []byte("z"), val: &val, children: []*node[string]{
+				{path: []byte("z")},
+			}},
+		}},
+	}}}
+	require.Error(t, uncompressed.Validate())
+	uncompressedDump := dumpTree(uncompressed)
+
+	compacted := uncompressed.Compact()
+	validateTree(t, compacted)
+	require.Equal(t, uncompressedDump, dumpTree(uncompressed), "original tree should be unmodified")
+	require.Equal(t, map[string]string{"abc": "val", "xz": "val"}, compacted.ToMap())
+	require.Equal(t, `""
+├── "abc" = val
+└── "xz" = val
+`, compacted.String())
+	require.Same(t, compacted, compacted.Compact())
+}
+
+func TestApproxSizeBytes(t *testing.T) {
+	t.Parallel()
+
+	empty := New[int64]().ApproxSizeBytes()
+	require.Positive(t, empty)
+
+	small := NewFromMap(map[string]int64{"a": 1}).ApproxSizeBytes()
+	require.Greater(t, small, empty)
+
+	longKey := NewFromMap(map[string]int64{strings.Repeat("a", 1000): 1}).ApproxSizeBytes()
+	require.Equal(t, small+999, longKey, "size should grow with the key bytes")
+
+	m := map[string]int64{}
+	for idx := range 100 {
+		m[fmt.Sprintf("key-%d", idx)] = int64(idx)
+	}
+	many := NewFromMap(m).ApproxSizeBytes()
+	require.Greater(t, many-empty, 50*(small-empty))
+
+	sized := NewWithOptions(WithValueSizer(func(val string) int { return len(val) }))
+	_, _, sized = sized.Insert([]byte("a"), strings.Repeat("x", 500))
+	_, _, unsized := New[string]().Insert([]byte("a"), strings.Repeat("x", 500))
+	require.Equal(t, unsized.ApproxSizeBytes()-int(unsafe.Sizeof(""))+500, sized.ApproxSizeBytes())
+}
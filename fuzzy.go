@@ -0,0 +1,66 @@
+package iradix
+
+import (
+	"iter"
+	"slices"
+)
+
+// FuzzyMatch yields all entries whose key is within Levenshtein distance
+// maxDist of query, in key order. It computes one row of the edit distance
+// matrix per key byte while descending and skips subtrees as soon as no
+// extension of the current key can get within maxDist anymore. Like with
+// Iterate, the yielded key aliases a buffer that is reused across iteration
+// steps and must be copied if it is retained.
+func (i *Iradix[T]) FuzzyMatch(query []byte, maxDist int) iter.Seq2[[]byte, T] {
+	return func(yield func([]byte, T) bool) {
+		// row[j] is the edit distance between the current key and
+		// query[:j].
+		row := make([]int, len(query)+1)
+		for j := range row {
+			row[j] = j
+		}
+
+		var match func(key []byte, row []int, n *node[T]) bool
+		match = func(key []byte, row []int, n *node[T]) bool {
+			for _, b := range n.path {
+				row = nextEditDistanceRow(row, query, b)
+				if slices.Min(row) > maxDist {
+					return true
+				}
+			}
+			key = append(key, n.path...)
+
+			if n.val != nil && row[len(query)] <= maxDist {
+				if !yield(key, *n.val) {
+					return false
+				}
+			}
+			for _, child := range n.children {
+				if !match(key, row, child) {
+					return false
+				}
+			}
+			return true
+		}
+		match(nil, row, i.root)
+	}
+}
+
+// nextEditDistanceRow returns the edit distance row for the key of row
+// extended by b.
+func nextEditDistanceRow(row []int, query []byte, b byte) []int {
+	next := make([]int, len(row))
+	next[0] = row[0] + 1
+	for j := 1; j < len(row); j++ {
+		substitutionCost := 1
+		if query[j-1] == b {
+			substitutionCost = 0
+		}
+		next[j] = min(
+			row[j]+1,                  // deletion
+			next[j-1]+1,               // insertion
+			row[j-1]+substitutionCost, // substitution
+		)
+	}
+	return next
+}
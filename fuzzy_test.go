@@ -0,0 +1,88 @@
+package iradix
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	t.Parallel()
+
+	words := []string{"", "a", "car", "card", "care", "cart", "cat", "cats", "dog", "scar", "scary"}
+	m := map[string]string{}
+	for _, word := range words {
+		m[word] = word + "-val"
+	}
+	tree := NewFromMap(m)
+
+	testCases := []struct {
+		query   string
+		maxDist int
+		expect  []string
+	}{
+		{query: "car", maxDist: 0, expect: []string{"car"}},
+		{query: "car", maxDist: 1, expect: []string{"car", "card", "care", "cart", "cat", "scar"}},
+		{query: "cr", maxDist: 1, expect: []string{"car"}},
+		{query: "cxr", maxDist: 1, expect: []string{"car"}},
+		{query: "carts", maxDist: 1, expect: []string{"cart", "cats"}},
+		{query: "", maxDist: 1, expect: []string{"", "a"}},
+		{query: "zzzzzzzz", maxDist: 2},
+	}
+
+	for _, tc := range testCases {
+		var got []string
+		for key, val := range tree.FuzzyMatch([]byte(tc.query), tc.maxDist) {
+			require.Equal(t, m[string(key)], val)
+			got = append(got, string(key))
+		}
+		require.Equal(t, tc.expect, got, "query %q with distance %d", tc.query, tc.maxDist)
+
+		// Verify against the unpruned distance of every key
+		var expected []string
+		for _, word := range words {
+			if levenshtein(word, tc.query) <= tc.maxDist {
+				expected = append(expected, word)
+			}
+		}
+		require.Equal(t, expected, got, "query %q with distance %d", tc.query, tc.maxDist)
+	}
+}
+
+func TestFuzzyMatchRetainedKeys(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{"xab": "xab-val", "xac": "xac-val"})
+
+	// The yielded key is reused, so retained keys must be copied.
+	var keys []string
+	var copied [][]byte
+	for key := range tree.FuzzyMatch([]byte("xaa"), 1) {
+		keys = append(keys, string(key))
+		copied = append(copied, slices.Clone(key))
+	}
+	require.Equal(t, []string{"xab", "xac"}, keys)
+	require.Equal(t, [][]byte{[]byte("xab"), []byte("xac")}, copied)
+}
+
+func levenshtein(a, b string) int {
+	dist := make([][]int, len(a)+1)
+	for i := range dist {
+		dist[i] = make([]int, len(b)+1)
+		dist[i][0] = i
+	}
+	for j := range len(b) + 1 {
+		dist[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			dist[i][j] = min(dist[i-1][j]+1, dist[i][j-1]+1, dist[i-1][j-1]+cost)
+		}
+	}
+	return dist[len(a)][len(b)]
+}
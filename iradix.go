@@ -2,168 +2,876 @@ package iradix
 
 import (
 	"bytes"
+	"fmt"
 	"iter"
-	"reflect"
+	"maps"
 	"slices"
 	"sort"
+	"sync/atomic"
 )
 
 func New[T any]() *Iradix[T] {
 	return &Iradix[T]{root: &node[T]{}}
 }
 
+// NewFromMap builds a tree holding all entries of m.
+func NewFromMap[T any](m map[string]T) *Iradix[T] {
+	// Inserting in sorted order keeps the construction independent of the
+	// map iteration order.
+	keys := slices.Sorted(maps.Keys(m))
+	return New[T]().InsertMany(func(yield func([]byte, T) bool) {
+		for _, key := range keys {
+			if !yield([]byte(key), m[key]) {
+				return
+			}
+		}
+	})
+}
+
+// BuildSorted builds a tree from pairs whose keys are in strictly ascending
+// order. Rather than inserting every key from the root, it appends each key
+// to the rightmost path of the tree built so far, which makes it about twice
+// as fast as InsertMany, see BenchmarkBuildSorted. It returns an error if a
+// key is not greater than its predecessor.
+func BuildSorted[T any](pairs iter.Seq2[[]byte, T]) (*Iradix[T], error) {
+	type spineNode struct {
+		n *node[T]
+		// end is the length of the key of n.
+		end int
+	}
+
+	root := &node[T]{}
+	// spine holds the path from the root to the node of the previous key.
+	spine := []spineNode{{n: root}}
+	var prev []byte
+	count := 0
+	for key, val := range pairs {
+		if count > 0 && bytes.Compare(key, prev) <= 0 {
+			return nil, fmt.Errorf("key %q is not greater than the previous key %q", key, prev)
+		}
+		if count == 0 && len(key) == 0 {
+			root.val = &val
+			root.subtreeSize++
+			count++
+			prev = prev[:0]
+			continue
+		}
+
+		// The new key branches off the spine at the end of the prefix it
+		// shares with the previous key, which ends within or after the
+		// path of the top of the spine once the nodes below are removed.
+		commonLen := commonPrefixLen(prev, key)
+		for len(spine) > 1 && spine[len(spine)-2].end >= commonLen {
+			spine = spine[:len(spine)-1]
+		}
+		if top := spine[len(spine)-1]; top.end > commonLen {
+			parent := spine[len(spine)-2].n
+			splitLen := len(top.n.path) - (top.end - commonLen)
+			splitNode := &node[T]{
+				path:        top.n.path[:splitLen:splitLen],
+				children:    []*node[T]{top.n},
+				subtreeSize: top.n.subtreeSize,
+			}
+			top.n.path = top.n.path[splitLen:]
+			parent.children[len(parent.children)-1] = splitNode
+			spine[len(spine)-1] = spineNode{n: splitNode, end: commonLen}
+		}
+
+		leaf := &node[T]{path: slices.Clone(key[commonLen:]), val: &val}
+		spine[len(spine)-1].n.children = append(spine[len(spine)-1].n.children, leaf)
+		spine = append(spine, spineNode{n: leaf, end: len(key)})
+		for _, sn := range spine {
+			sn.n.subtreeSize++
+		}
+
+		count++
+		prev = append(prev[:0], key...)
+	}
+
+	return &Iradix[T]{root: root, len: count}, nil
+}
+
 type Iradix[T any] struct {
 	root *node[T]
 	len  int
+	cfg  *config[T]
+	// version is incremented for every tree derived from another one.
+	version uint64
 }
 
 func (i *Iradix[T]) Get(key []byte) (T, bool) {
-	currentNode := i.root
+	if n := i.find(key); n != nil && n.val != nil {
+		return i.cfg.copyValue(*n.val), true
+	}
+
+	return *new(T), false
+}
+
+// GetOrDefault is like Get, but returns the default value of a tree configured
+// with WithDefault if key is absent. Other trees return the zero value.
+func (i *Iradix[T]) GetOrDefault(key []byte) T {
+	if val, ok := i.Get(key); ok {
+		return val
+	}
+	return i.cfg.copyValue(i.cfg.defaultValue())
+}
 
+// GetWithKey is like Get but additionally returns the stored key, built from
+// the paths of the nodes that were matched. The returned key is a copy.
+func (i *Iradix[T]) GetWithKey(key []byte) (storedKey []byte, val T, ok bool) {
+	fold := i.cfg.foldsCase()
+	currentNode := i.root
 	for len(key) > 0 {
 		childIdx := findChild(currentNode.children, key[0])
+		if childIdx == -1 && fold {
+			childIdx = findToggledChild(currentNode.children, key[0])
+		}
 		if childIdx == -1 {
-			return *new(T), false
+			return nil, val, false
 		}
-
 		child := currentNode.children[childIdx]
-		if !bytes.HasPrefix(key, child.path) {
-			return *new(T), false
+		if !bytes.HasPrefix(key, child.path) && (!fold || !hasPrefixFold(key, child.path)) {
+			return nil, val, false
 		}
 
+		storedKey = append(storedKey, child.path...)
 		key = key[len(child.path):]
 		currentNode = child
 	}
 
-	if currentNode.val != nil {
-		return *currentNode.val, true
+	if currentNode.val == nil {
+		return nil, val, false
 	}
+	return storedKey, i.cfg.copyValue(*currentNode.val), true
+}
 
-	return *new(T), false
+// Nearest returns the entry whose key shares the longest common prefix with
+// key, preferring the smaller key on ties. It only returns false if the tree
+// is empty.
+func (i *Iradix[T]) Nearest(key []byte) ([]byte, T, bool) {
+	currentNode, nearestKey := i.root, []byte(nil)
+	for len(key) > 0 {
+		childIdx := findChild(currentNode.children, key[0])
+		if childIdx == -1 {
+			break
+		}
+		child := currentNode.children[childIdx]
+		nearestKey = append(nearestKey, child.path...)
+		currentNode = child
+		if !bytes.HasPrefix(key, child.path) {
+			// key diverges within the path of child, so all keys
+			// below it share the longest possible prefix with key.
+			break
+		}
+		key = key[len(child.path):]
+	}
+
+	// All keys below currentNode share the same prefix with key, so the
+	// smallest of them wins.
+	for currentNode.val == nil {
+		if len(currentNode.children) == 0 {
+			return nil, *new(T), false
+		}
+		currentNode = currentNode.children[0]
+		nearestKey = append(nearestKey, currentNode.path...)
+	}
+	return nearestKey, i.cfg.copyValue(*currentNode.val), true
 }
 
-func (i *Iradix[T]) Insert(key []byte, val T) (oldVal T, existed bool, newTree *Iradix[T]) {
-	if oldVal, exists := i.Get(key); exists && reflect.DeepEqual(oldVal, val) {
-		return oldVal, true, i
+// Result is the outcome of looking up a single key in GetMany.
+type Result[T any] struct {
+	Val   T
+	Found bool
+}
+
+// GetMany looks up all keys and returns the results in the order of keys.
+// Consecutive keys reuse the descent into the prefix they share, so passing
+// keys sorted or grouped by prefix is faster than calling Get for each.
+// Sorting the keys here instead turned out to cost more than it saves.
+func (i *Iradix[T]) GetMany(keys [][]byte) []Result[T] {
+	type pathEntry struct {
+		n        *node[T]
+		consumed int
 	}
-	newRoot := copyNode(i.root)
-	if len(key) == 0 {
-		if newRoot.val != nil {
-			oldVal, existed = *newRoot.val, true
+	fold := i.cfg.foldsCase()
+	results := make([]Result[T], len(keys))
+	path := []pathEntry{{n: i.root}}
+	var prevKey []byte
+	for idx, key := range keys {
+
+		// Nodes on the path of the previous key remain valid as long as
+		// they are within the prefix both keys share.
+		commonLen := commonPrefixLen(prevKey, key)
+		for path[len(path)-1].consumed > commonLen {
+			path = path[:len(path)-1]
+		}
+		prevKey = key
+
+		currentNode, consumed := path[len(path)-1].n, path[len(path)-1].consumed
+		for consumed < len(key) {
+			childIdx := findChild(currentNode.children, key[consumed])
+			if childIdx == -1 && fold {
+				childIdx = findToggledChild(currentNode.children, key[consumed])
+			}
+			if childIdx == -1 {
+				break
+			}
+			child := currentNode.children[childIdx]
+			if !bytes.HasPrefix(key[consumed:], child.path) && (!fold || !hasPrefixFold(key[consumed:], child.path)) {
+				break
+			}
+
+			consumed += len(child.path)
+			currentNode = child
+			path = append(path, pathEntry{n: currentNode, consumed: consumed})
 		}
-		newRoot.val = &val
-		return oldVal, existed, &Iradix[T]{
-			root: newRoot,
-			len:  i.len + 1,
+
+		if consumed == len(key) && currentNode.val != nil {
+			results[idx] = Result[T]{Val: i.cfg.copyValue(*currentNode.val), Found: true}
 		}
 	}
 
-	currentNode := newRoot
+	return results
+}
+
+// GetWatch is like Get but additionally returns a channel that is closed
+// once a tree derived from i modifies key. It watches a single node: the one
+// holding key or, if key is absent, the deepest existing node on the path to
+// it, which inserting key would copy. Every mutation copying that node closes
+// the channel, including inserts and deletes of keys below it. Mutations that
+// only copy its ancestors, like inserting a key in another branch, don't.
+func (i *Iradix[T]) GetWatch(key []byte) (<-chan struct{}, T, bool) {
+	// Watch the deepest node on the path, as inserting key would copy it.
+	fold := i.cfg.foldsCase()
+	currentNode := i.root
 	for len(key) > 0 {
 		childIdx := findChild(currentNode.children, key[0])
+		if childIdx == -1 && fold {
+			childIdx = findToggledChild(currentNode.children, key[0])
+		}
+		if childIdx == -1 {
+			break
+		}
+		child := currentNode.children[childIdx]
+		if !bytes.HasPrefix(key, child.path) && (!fold || !hasPrefixFold(key, child.path)) {
+			break
+		}
+
+		key = key[len(child.path):]
+		currentNode = child
+	}
+
+	if len(key) == 0 && currentNode.val != nil {
+		return currentNode.watch(), i.cfg.copyValue(*currentNode.val), true
+	}
+	return currentNode.watch(), *new(T), false
+}
 
+// WatchPrefix returns a channel that is closed once a tree derived from i
+// adds, modifies or removes any key starting with prefix. It watches the
+// node covering all these keys, which every such mutation copies. If there
+// is none, it watches the deepest node on the path to prefix like GetWatch,
+// so it may also be closed by mutations of other keys below that node. It is
+// also closed if a delete merges the covering node with its parent.
+func (i *Iradix[T]) WatchPrefix(prefix []byte) <-chan struct{} {
+	currentNode := i.root
+	for len(prefix) > 0 {
+		childIdx := findChild(currentNode.children, prefix[0])
 		if childIdx == -1 {
-			newChild := &node[T]{
-				path: slices.Clone(key),
-				val:  &val,
+			break
+		}
+		child := currentNode.children[childIdx]
+		commonLen := commonPrefixLen(prefix, child.path)
+		if commonLen == len(prefix) {
+			return child.watch()
+		}
+		if commonLen < len(child.path) {
+			break
+		}
+
+		prefix = prefix[commonLen:]
+		currentNode = child
+	}
+
+	return currentNode.watch()
+}
+
+// LongestPrefixLen returns the length and value of the longest stored key
+// that is a prefix of key.
+func (i *Iradix[T]) LongestPrefixLen(key []byte) (matchedLen int, val T, ok bool) {
+	for prefix, prefixVal := range i.WalkPath(key) {
+		matchedLen, val, ok = len(prefix), prefixVal, true
+	}
+	return matchedLen, val, ok
+}
+
+// WalkPath yields all stored keys that are a prefix of key, from shortest to
+// longest. The yielded keys are sub-slices of key, so in trees configured with
+// WithCaseFold they have the casing of key rather than the stored one.
+func (i *Iradix[T]) WalkPath(key []byte) iter.Seq2[[]byte, T] {
+	return func(yield func([]byte, T) bool) {
+		fold := i.cfg.foldsCase()
+		currentNode, consumed := i.root, 0
+		for {
+			if currentNode.val != nil && !yield(key[:consumed], *currentNode.val) {
+				return
+			}
+			if consumed == len(key) {
+				return
+			}
+
+			childIdx := findChild(currentNode.children, key[consumed])
+			if childIdx == -1 && fold {
+				childIdx = findToggledChild(currentNode.children, key[consumed])
 			}
-			insertChild(currentNode, newChild)
-			return oldVal, existed, &Iradix[T]{
-				root: newRoot,
-				len:  i.len + 1,
+			if childIdx == -1 {
+				return
 			}
+			child := currentNode.children[childIdx]
+			if !bytes.HasPrefix(key[consumed:], child.path) && (!fold || !hasPrefixFold(key[consumed:], child.path)) {
+				return
+			}
+
+			consumed += len(child.path)
+			currentNode = child
+		}
+	}
+}
+
+// MatchingPrefixes returns all stored keys that are a prefix of key, from
+// shortest to longest.
+func (i *Iradix[T]) MatchingPrefixes(key []byte) [][]byte {
+	prefixes := [][]byte{}
+	for prefix := range i.WalkPath(key) {
+		prefixes = append(prefixes, slices.Clone(prefix))
+	}
+	return prefixes
+}
+
+// GetRef is like Get but returns a pointer to the stored value rather than a
+// copy of it, which avoids copying large values. The value is shared with
+// every tree derived from i and must not be modified. Trees configured with
+// WithDeepCopy return a pointer to a copy instead.
+func (i *Iradix[T]) GetRef(key []byte) (*T, bool) {
+	if n := i.find(key); n != nil && n.val != nil {
+		if i.cfg != nil && i.cfg.copyVal != nil {
+			val := i.cfg.copyVal(*n.val)
+			return &val, true
+		}
+		return n.val, true
+	}
+
+	return nil, false
+}
+
+// Contains reports whether a value is stored under key.
+func (i *Iradix[T]) Contains(key []byte) bool {
+	n := i.find(key)
+	return n != nil && n.val != nil
+}
+
+// GetFunc reports whether a value is stored under key and pred returns true
+// for it. pred is only called if key is present.
+func (i *Iradix[T]) GetFunc(key []byte, pred func(T) bool) bool {
+	n := i.find(key)
+	return n != nil && n.val != nil && pred(*n.val)
+}
+
+// find returns the node whose path ends exactly at key, or nil if there is
+// none. The returned node may not hold a value.
+func (i *Iradix[T]) find(key []byte) *node[T] {
+	fold := i.cfg.foldsCase()
+	currentNode := i.root
+
+	for len(key) > 0 {
+		childIdx := findChild(currentNode.children, key[0])
+		if childIdx == -1 && fold {
+			childIdx = findToggledChild(currentNode.children, key[0])
+		}
+		if childIdx == -1 {
+			return nil
 		}
 
 		child := currentNode.children[childIdx]
-		commonLen := commonPrefixLen(key, child.path)
+		if !bytes.HasPrefix(key, child.path) && (!fold || !hasPrefixFold(key, child.path)) {
+			return nil
+		}
 
-		if commonLen == len(child.path) {
-			newChild := copyNode(child)
-			currentNode.children[childIdx] = newChild
-			currentNode = newChild
-			key = key[commonLen:]
-		} else {
-			splitNode := &node[T]{
-				path: child.path[:commonLen],
-			}
-			childCopy := copyNode(child)
-			childCopy.path = child.path[commonLen:]
-			insertChild(splitNode, childCopy)
-
-			if commonLen == len(key) {
-				splitNode.val = &val
-			} else {
-				newChild := &node[T]{
-					path: slices.Clone(key[commonLen:]),
-					val:  &val,
-				}
-				insertChild(splitNode, newChild)
-			}
+		key = key[len(child.path):]
+		currentNode = child
+	}
 
-			currentNode.children[childIdx] = splitNode
-			return oldVal, existed, &Iradix[T]{
-				root: newRoot,
-				len:  i.len + 1,
-			}
+	return currentNode
+}
+
+// Insert stores val under key and returns the previously stored value, if
+// any, along with the resulting tree. Like all other operations writing a
+// key, it panics if the tree was configured with WithMaxKeyLen and key is
+// too long, see TryInsert.
+func (i *Iradix[T]) Insert(key []byte, val T) (oldVal T, existed bool, newTree *Iradix[T]) {
+	t := i.singleTxn()
+	oldVal, existed = t.insert(key, val)
+	return i.cfg.copyValue(oldVal), existed, t.commit(i)
+}
+
+// TryInsert is like Insert, but rather than panicking it returns an error
+// wrapping ErrKeyTooLong along with i itself if the tree was configured with
+// WithMaxKeyLen and key is too long. Use it for keys from untrusted
+// sources.
+func (i *Iradix[T]) TryInsert(key []byte, val T) (oldVal T, existed bool, newTree *Iradix[T], err error) {
+	if err := i.cfg.checkKeyLen(key); err != nil {
+		return oldVal, false, i, err
+	}
+	oldVal, existed, newTree = i.Insert(key, val)
+	return oldVal, existed, newTree, nil
+}
+
+// GetOrInsert returns the value stored under key if there is one. Otherwise
+// it inserts val and returns it together with the new tree.
+func (i *Iradix[T]) GetOrInsert(key []byte, val T) (actual T, loaded bool, newTree *Iradix[T]) {
+	return i.GetOrInsertFunc(key, func() T { return val })
+}
+
+// GetOrInsertFunc is like GetOrInsert, but only calls f to obtain the value
+// to insert if key is not present.
+func (i *Iradix[T]) GetOrInsertFunc(key []byte, f func() T) (actual T, loaded bool, newTree *Iradix[T]) {
+	t := i.singleTxn()
+	t.upsert(key, func(old T, exists bool) (T, bool) {
+		if exists {
+			actual, loaded = old, true
+			return old, false
 		}
+		actual = f()
+		return actual, true
+	})
+	if loaded {
+		return i.cfg.copyValue(actual), true, i
+	}
+
+	return actual, false, t.commit(i)
+}
+
+// InsertIfAbsent inserts val only if key is not present yet. It returns the
+// value stored under key afterwards and whether the insert happened.
+func (i *Iradix[T]) InsertIfAbsent(key []byte, val T) (current T, inserted bool, newTree *Iradix[T]) {
+	current, loaded, newTree := i.GetOrInsert(key, val)
+	return current, !loaded, newTree
+}
+
+// Replace stores val under key only if key is already present, returning
+// the previously stored value. If key is absent, replaced is false and the
+// tree is returned as-is, so a key is never created by accident. Like
+// InsertChanged, CompareAndSwap and Update, Replace stores val as given and
+// doesn't consult the function passed to WithOnConflict. Keys exceeding
+// the limit of WithMaxKeyLen can't be present, so they are reported as
+// absent rather than causing a panic.
+func (i *Iradix[T]) Replace(key []byte, val T) (oldVal T, replaced bool, newTree *Iradix[T]) {
+	if i.cfg.checkKeyLen(key) != nil {
+		return oldVal, false, i
 	}
+	t := i.singleTxn()
+	t.upsert(key, func(old T, exists bool) (T, bool) {
+		if !exists {
+			return old, false
+		}
+		oldVal, replaced = old, true
+		return val, !t.cfg.equal(old, val)
+	})
+	return i.cfg.copyValue(oldVal), replaced, t.commit(i)
+}
+
+// CompareAndSwap stores newVal under key only if key is present and its
+// value equals oldVal according to eq. Otherwise the tree is returned as-is.
+func (i *Iradix[T]) CompareAndSwap(key []byte, oldVal, newVal T, eq func(a, b T) bool) (swapped bool, newTree *Iradix[T]) {
+	t := i.singleTxn()
+	t.upsert(key, func(current T, exists bool) (T, bool) {
+		swapped = exists && eq(current, oldVal)
+		return newVal, swapped
+	})
+	return swapped, t.commit(i)
+}
 
-	if currentNode.val != nil {
-		oldVal, existed = *currentNode.val, true
+// InsertChanged stores val under key unless a value equal to it according to
+// eq is already stored there. changed is true if key was absent or held a
+// different value, and false if the tree is returned as-is.
+func (i *Iradix[T]) InsertChanged(key []byte, val T, eq func(a, b T) bool) (oldVal T, changed bool, newTree *Iradix[T]) {
+	t := i.singleTxn()
+	t.upsert(key, func(old T, exists bool) (T, bool) {
+		oldVal, changed = old, !exists || !eq(old, val)
+		return val, changed
+	})
+	return i.cfg.copyValue(oldVal), changed, t.commit(i)
+}
+
+// Update stores the result of calling f with the value currently stored
+// under key, or the zero value and false if there is none.
+func (i *Iradix[T]) Update(key []byte, f func(old T, existed bool) T) (newVal T, newTree *Iradix[T]) {
+	t := i.singleTxn()
+	t.upsert(key, func(old T, exists bool) (T, bool) {
+		newVal = f(old, exists)
+		return newVal, true
+	})
+	return newVal, t.commit(i)
+}
+
+// InsertMany inserts all pairs and returns the resulting tree. All insertions
+// are applied to a single transaction in which every node is copied at most
+// once, whereas chained Insert calls copy the whole path for every key. For
+// 10k keys with shared prefixes this is about 3x faster and allocates about
+// 4x less, see BenchmarkInsertMany.
+func (i *Iradix[T]) InsertMany(pairs iter.Seq2[[]byte, T]) *Iradix[T] {
+	t := i.txn()
+	for key, val := range pairs {
+		t.insert(key, val)
 	}
-	currentNode.val = &val
+	return t.commit(i)
+}
 
-	return oldVal, existed, &Iradix[T]{root: newRoot, len: i.len + 1}
+// Merge returns a tree holding the entries of both trees. For keys present
+// in both, the stored value is resolve(a, b) with a taken from i and b from
+// other. It performs O(other.Len()) insertions into a single transaction.
+func (i *Iradix[T]) Merge(other *Iradix[T], resolve func(a, b T) T) *Iradix[T] {
+	t := i.txn()
+	for key, val := range other.Iterate() {
+		t.upsert(key, func(old T, exists bool) (T, bool) {
+			if exists {
+				return resolve(old, val), true
+			}
+			return val, true
+		})
+	}
+	return t.commit(i)
 }
 
 func (i *Iradix[T]) Delete(key []byte) (oldVal T, existed bool, newTree *Iradix[T]) {
-	if _, exists := i.Get(key); !exists {
-		return oldVal, existed, i
+	t := i.singleTxn()
+	oldVal, existed = t.delete(key)
+	return i.cfg.copyValue(oldVal), existed, t.commit(i)
+}
+
+// GetRoot returns the value stored under the empty key.
+func (i *Iradix[T]) GetRoot() (T, bool) {
+	return i.Get(nil)
+}
+
+// SetRoot stores val under the empty key.
+func (i *Iradix[T]) SetRoot(val T) *Iradix[T] {
+	_, _, newTree := i.Insert(nil, val)
+	return newTree
+}
+
+// DeleteRoot deletes the value stored under the empty key.
+func (i *Iradix[T]) DeleteRoot() (oldVal T, existed bool, newTree *Iradix[T]) {
+	return i.Delete(nil)
+}
+
+// DeleteMany deletes all keys and returns the resulting tree along with the
+// number of keys that were present. Like InsertMany, it applies all deletions
+// to a single transaction in which every node is copied at most once.
+func (i *Iradix[T]) DeleteMany(keys [][]byte) (newTree *Iradix[T], deleted int) {
+	t := i.txn()
+	for _, key := range keys {
+		if _, existed := t.delete(key); existed {
+			deleted++
+		}
+	}
+	return t.commit(i), deleted
+}
+
+// SubTree returns a tree holding all entries whose key starts with prefix,
+// with prefix stripped from their keys. It returns false if there are none.
+func (i *Iradix[T]) SubTree(prefix []byte) (*Iradix[T], bool) {
+	n, suffix := i.findPrefix(prefix)
+	if n == nil {
+		return nil, false
+	}
+	if n.subtreeSize == 0 {
+		return nil, false
 	}
 
-	newRoot := copyNode(i.root)
-	var parents []*node[T]
-	var childIndices []int
+	root := &node[T]{val: n.val, children: n.children, subtreeSize: n.subtreeSize}
+	if len(suffix) > 0 {
+		// The prefix ends within the path of n, so n becomes the only
+		// child of the new root with the rest of its path.
+		root = &node[T]{children: []*node[T]{{
+			path:        suffix,
+			val:         n.val,
+			children:    n.children,
+			subtreeSize: n.subtreeSize,
+		}}, subtreeSize: n.subtreeSize}
+	}
 
-	currentNode := newRoot
-	for len(key) > 0 {
-		childIdx := findChild(currentNode.children, key[0])
+	return i.derive(root, n.subtreeSize), true
+}
+
+// HasPrefix reports whether any key starts with prefix.
+func (i *Iradix[T]) HasPrefix(prefix []byte) bool {
+	n, _ := i.findPrefix(prefix)
+	return n != nil && n.subtreeSize > 0
+}
+
+// CountPrefix returns the number of keys starting with prefix. It only needs
+// to descend to the prefix, as every node knows the number of values below it.
+func (i *Iradix[T]) CountPrefix(prefix []byte) int {
+	n, _ := i.findPrefix(prefix)
+	if n == nil {
+		return 0
+	}
+	return n.subtreeSize
+}
+
+// ValuesPrefix returns the values of all keys starting with prefix in the
+// lexicographic order of their keys, or nil if there are none.
+func (i *Iradix[T]) ValuesPrefix(prefix []byte) []T {
+	n, _ := i.findPrefix(prefix)
+	if n == nil || n.subtreeSize == 0 {
+		return nil
+	}
+
+	vals := make([]T, 0, n.subtreeSize)
+	var collect func(n *node[T])
+	collect = func(n *node[T]) {
+		if n.val != nil {
+			vals = append(vals, *n.val)
+		}
+		for _, child := range n.children {
+			collect(child)
+		}
+	}
+	collect(n)
+	return vals
+}
+
+// CountFunc returns the number of entries for which pred returns true. The
+// key passed to pred is only valid for the duration of the call.
+func (i *Iradix[T]) CountFunc(pred func(key []byte, val T) bool) int {
+	count := 0
+	for key, val := range i.Iterate() {
+		if pred(key, val) {
+			count++
+		}
+	}
+	return count
+}
 
+// Suggest returns up to n keys starting with prefix in lexicographic order.
+// It stops walking the tree once n keys have been found.
+func (i *Iradix[T]) Suggest(prefix []byte, n int) [][]byte {
+	keys := [][]byte{}
+	covering, suffix := i.findPrefix(prefix)
+	if covering == nil || n <= 0 {
+		return keys
+	}
+
+	key := append(slices.Clone(prefix), suffix...)
+	iterateSubtree(key, covering, func(key []byte, _ T) bool {
+		keys = append(keys, slices.Clone(key))
+		return len(keys) < n
+	})
+	return keys
+}
+
+// CommonPrefix returns the longest prefix shared by all keys. It is empty if
+// the tree is empty or holds the empty key.
+func (i *Iradix[T]) CommonPrefix() []byte {
+	// Since valueless nodes other than the root have at least two children,
+	// the common prefix is the path of the only child of the root, if any.
+	if i.root.val != nil || len(i.root.children) != 1 {
+		return []byte{}
+	}
+	return slices.Clone(i.root.children[0].path)
+}
+
+// CommonAncestor returns the key of the deepest node whose key is a prefix of
+// both a and b, which is where the paths of both keys through the tree
+// diverge. Keys that diverge within the compressed path of a node share the
+// key of its parent rather than their byte-wise common prefix. The result is
+// a copy and empty if they diverge at the root.
+func (i *Iradix[T]) CommonAncestor(a, b []byte) []byte {
+	fold := i.cfg.foldsCase()
+	ancestor := []byte{}
+	currentNode := i.root
+	for len(a) > 0 && len(b) > 0 {
+		childIdx := findChild(currentNode.children, a[0])
+		if childIdx == -1 && fold {
+			childIdx = findToggledChild(currentNode.children, a[0])
+		}
+		if childIdx == -1 {
+			break
+		}
 		child := currentNode.children[childIdx]
-		parents = append(parents, currentNode)
-		childIndices = append(childIndices, childIdx)
-		currentNode = copyNode(child)
-		parents[len(parents)-1].children[childIdx] = currentNode
-		key = key[len(currentNode.path):]
-	}
-
-	if currentNode.val != nil {
-		oldVal, existed = *currentNode.val, true
-		currentNode.val = nil
-	}
-
-	// Clean up empty nodes and compress single-child chains
-	for idx := len(parents) - 1; idx >= 0; idx-- {
-		parent := parents[idx]
-		childIdx := childIndices[idx]
-
-		if currentNode.val == nil && len(currentNode.children) == 0 {
-			parent.children = slices.Delete(parent.children, childIdx, childIdx+1)
-		} else if currentNode.val == nil && len(currentNode.children) == 1 {
-			onlyChild := currentNode.children[0]
-			currentNode.path = append(slices.Clone(currentNode.path), onlyChild.path...)
-			currentNode.val = onlyChild.val
-			currentNode.children = onlyChild.children
-		} else {
+		if !bytes.HasPrefix(a, child.path) && (!fold || !hasPrefixFold(a, child.path)) ||
+			!bytes.HasPrefix(b, child.path) && (!fold || !hasPrefixFold(b, child.path)) {
 			break
 		}
 
-		currentNode = parent
+		ancestor = append(ancestor, child.path...)
+		a, b = a[len(child.path):], b[len(child.path):]
+		currentNode = child
 	}
+	return ancestor
+}
 
-	return oldVal, existed, &Iradix[T]{root: newRoot, len: i.len - 1}
+// FirstN returns up to n entries with the smallest keys in ascending order.
+// It stops walking the tree once n entries have been found.
+func (i *Iradix[T]) FirstN(n int) ([][]byte, []T) {
+	if n <= 0 {
+		return nil, nil
+	}
+	keys, vals := make([][]byte, 0, min(n, i.len)), make([]T, 0, min(n, i.len))
+	iterateSubtree(nil, i.root, func(key []byte, val T) bool {
+		keys = append(keys, slices.Clone(key))
+		vals = append(vals, val)
+		return len(keys) < n
+	})
+	return keys, vals
+}
+
+// LastN returns up to n entries with the largest keys in ascending order.
+// It walks the tree backwards and stops once n entries have been found.
+func (i *Iradix[T]) LastN(n int) ([][]byte, []T) {
+	if n <= 0 {
+		return nil, nil
+	}
+	keys, vals := make([][]byte, 0, min(n, i.len)), make([]T, 0, min(n, i.len))
+	reverseIterateSubtree(nil, i.root, func(key []byte, val T) bool {
+		keys = append(keys, slices.Clone(key))
+		vals = append(vals, val)
+		return len(keys) < n
+	})
+	slices.Reverse(keys)
+	slices.Reverse(vals)
+	return keys, vals
 }
 
+// At returns the entry at index n in key order, starting at zero. It uses
+// the subtree sizes to descend straight to the entry. The returned key is a
+// fresh copy.
+func (i *Iradix[T]) At(n int) ([]byte, T, bool) {
+	if n < 0 || n >= i.len {
+		return nil, *new(T), false
+	}
+
+	var key []byte
+	currentNode := i.root
+	for {
+		if currentNode.val != nil {
+			if n == 0 {
+				return key, i.cfg.copyValue(*currentNode.val), true
+			}
+			n--
+		}
+		for _, child := range currentNode.children {
+			if n < child.subtreeSize {
+				key = append(key, child.path...)
+				currentNode = child
+				break
+			}
+			n -= child.subtreeSize
+		}
+	}
+}
+
+// Rank returns the number of keys strictly less than key, regardless of
+// whether key itself is present. Like At, it uses the subtree sizes to only
+// descend along key.
+func (i *Iradix[T]) Rank(key []byte) int {
+	rank := 0
+	currentNode := i.root
+	for len(key) > 0 {
+		// The key of currentNode is a proper prefix of key and thus smaller.
+		if currentNode.val != nil {
+			rank++
+		}
+
+		var next *node[T]
+		for _, child := range currentNode.children {
+			if child.path[0] < key[0] {
+				rank += child.subtreeSize
+				continue
+			}
+			if child.path[0] == key[0] {
+				next = child
+			}
+			break
+		}
+		if next == nil {
+			return rank
+		}
+
+		commonLen := commonPrefixLen(key, next.path)
+		if commonLen < len(next.path) {
+			// key diverges within or ends within the path of next, so
+			// either all keys below it are smaller or none is.
+			if commonLen < len(key) && next.path[commonLen] < key[commonLen] {
+				rank += next.subtreeSize
+			}
+			return rank
+		}
+		key = key[commonLen:]
+		currentNode = next
+	}
+	return rank
+}
+
+// IteratePrefixReverse yields all entries whose key starts with prefix in
+// descending key order. Like with Iterate, the yielded key aliases a buffer
+// that is reused across iteration steps.
+func (i *Iradix[T]) IteratePrefixReverse(prefix []byte) iter.Seq2[[]byte, T] {
+	return func(yield func([]byte, T) bool) {
+		n, suffix := i.findPrefix(prefix)
+		if n == nil {
+			return
+		}
+		reverseIterateSubtree(slices.Concat(prefix, suffix), n, yield)
+	}
+}
+
+// IterateRelative yields all entries whose key starts with prefix in key
+// order, with prefix stripped from their keys. Unlike SubTree, it doesn't
+// build a new tree. Like with Iterate, the yielded key aliases a buffer that
+// is reused across iteration steps.
+func (i *Iradix[T]) IterateRelative(prefix []byte) iter.Seq2[[]byte, T] {
+	return func(yield func([]byte, T) bool) {
+		n, suffix := i.findPrefix(prefix)
+		if n == nil {
+			return
+		}
+		iterateSubtree(slices.Clone(suffix), n, yield)
+	}
+}
+
+// findPrefix returns the node below which all keys starting with prefix are
+// stored, along with the part of its path that extends beyond prefix. It
+// returns nil if no key starts with prefix.
+func (i *Iradix[T]) findPrefix(prefix []byte) (n *node[T], suffix []byte) {
+	currentNode := i.root
+
+	for len(prefix) > 0 {
+		childIdx := findChild(currentNode.children, prefix[0])
+		if childIdx == -1 {
+			return nil, nil
+		}
+
+		child := currentNode.children[childIdx]
+		commonLen := commonPrefixLen(prefix, child.path)
+		if commonLen == len(prefix) {
+			return child, child.path[commonLen:]
+		}
+		if commonLen < len(child.path) {
+			return nil, nil
+		}
+
+		prefix = prefix[commonLen:]
+		currentNode = child
+	}
+
+	return currentNode, nil
+}
+
+// Iterate yields all entries in key order. To avoid allocating per entry,
+// the yielded key aliases a buffer that is reused across iteration steps and
+// must be copied if it is retained, see Keys for a copying variant.
 func (i Iradix[T]) Iterate() iter.Seq2[[]byte, T] {
 	return func(yield func([]byte, T) bool) {
 		buf := make([]byte, 0, 64)
@@ -198,20 +906,564 @@ func (i Iradix[T]) Iterate() iter.Seq2[[]byte, T] {
 	}
 }
 
+// IterateLeaves is like Iterate but only yields entries stored in leaves,
+// that is, nodes without children. Due to path compression these are
+// exactly the entries whose key isn't a prefix of any other key, so unlike
+// Iterate it skips "a" in a tree that also holds "ab".
+func (i *Iradix[T]) IterateLeaves() iter.Seq2[[]byte, T] {
+	return func(yield func([]byte, T) bool) {
+		var iterate func(key []byte, n *node[T]) bool
+		iterate = func(key []byte, n *node[T]) bool {
+			if len(n.children) == 0 {
+				return n.val == nil || yield(key, *n.val)
+			}
+			for _, child := range n.children {
+				if !iterate(append(key, child.path...), child) {
+					return false
+				}
+			}
+			return true
+		}
+		iterate(nil, i.root)
+	}
+}
+
+// IterateGroups yields the entries grouped by the part of their key up to
+// and including the first sep, in key order. Keys without sep form a group of
+// their own. For keys "a", "a/b" and "a/c", the groups are "a" holding only
+// "a" and "a/" holding the other two. Since all keys of a group start with
+// it, groups map to subtrees and are yielded without inspecting every key.
+// The group iterators yield full keys, they stay valid after the outer
+// iteration advanced.
+func (i *Iradix[T]) IterateGroups(sep byte) iter.Seq2[[]byte, iter.Seq2[[]byte, T]] {
+	return func(yield func([]byte, iter.Seq2[[]byte, T]) bool) {
+		var iterate func(key []byte, n *node[T]) bool
+		iterate = func(key []byte, n *node[T]) bool {
+			if sepIdx := bytes.IndexByte(n.path, sep); sepIdx != -1 {
+				// All keys below n share the group.
+				nodeKey := slices.Clone(key)
+				group := slices.Clone(key[:len(key)-len(n.path)+sepIdx+1])
+				return yield(group, func(yield func([]byte, T) bool) {
+					iterateSubtree(slices.Clone(nodeKey), n, yield)
+				})
+			}
+
+			if n.val != nil {
+				nodeKey, val := slices.Clone(key), *n.val
+				if !yield(slices.Clone(key), func(yield func([]byte, T) bool) { yield(nodeKey, val) }) {
+					return false
+				}
+			}
+			for _, child := range n.children {
+				if !iterate(append(key, child.path...), child) {
+					return false
+				}
+			}
+			return true
+		}
+		iterate(nil, i.root)
+	}
+}
+
+// IterateUnsafe is the same as Iterate, which already reuses a single key
+// buffer across iteration steps, see BenchmarkIterate. It exists to make
+// explicit at the call site that the yielded key must be copied if it is
+// retained.
+func (i *Iradix[T]) IterateUnsafe() iter.Seq2[[]byte, T] {
+	return i.Iterate()
+}
+
+// Entry is a single entry yielded by IterateWithDepth.
+type Entry[T any] struct {
+	Key []byte
+	Val T
+	// Depth is the number of nodes between the root and the node holding
+	// the entry, so the empty key has a depth of zero. Due to path
+	// compression it is unrelated to the length of Key.
+	Depth int
+}
+
+// IterateWithDepth yields all entries in the same order as Iterate along
+// with their depth in the tree. Every key is a fresh copy.
+func (i *Iradix[T]) IterateWithDepth() iter.Seq[Entry[T]] {
+	return func(yield func(Entry[T]) bool) {
+		var iterate func(key []byte, n *node[T], depth int) bool
+		iterate = func(key []byte, n *node[T], depth int) bool {
+			if n.val != nil && !yield(Entry[T]{Key: slices.Clone(key), Val: *n.val, Depth: depth}) {
+				return false
+			}
+			for _, child := range n.children {
+				if !iterate(append(key, child.path...), child, depth+1) {
+					return false
+				}
+			}
+			return true
+		}
+		iterate(nil, i.root, 0)
+	}
+}
+
+// IterateBudget yields entries in key order as long as their cumulative size
+// doesn't exceed maxBytes. The size of an entry is the length of its key plus
+// the size of its value, which defaults to the size of T and can be
+// configured with WithValueSizer. Iteration stops at the first entry that
+// doesn't fit, so nothing is yielded if the first entry alone exceeds
+// maxBytes. Like with Iterate, the yielded key aliases a buffer that is
+// reused across iteration steps.
+func (i *Iradix[T]) IterateBudget(maxBytes int) iter.Seq2[[]byte, T] {
+	return func(yield func([]byte, T) bool) {
+		remaining := maxBytes
+		for key, val := range i.Iterate() {
+			remaining -= len(key) + i.cfg.sizeOf(val)
+			if remaining < 0 || !yield(key, val) {
+				return
+			}
+		}
+	}
+}
+
+// DeleteFunc deletes all entries for which pred returns true and returns the
+// resulting tree along with the number of deleted entries.
+func (i *Iradix[T]) DeleteFunc(pred func(key []byte, val T) bool) (newTree *Iradix[T], deleted int) {
+	var keys [][]byte
+	for key, val := range i.Iterate() {
+		if pred(key, val) {
+			keys = append(keys, slices.Clone(key))
+		}
+	}
+
+	return i.DeleteMany(keys)
+}
+
+// ReplacePrefix moves every entry whose key starts with oldPrefix to the key
+// with oldPrefix replaced by newPrefix, overwriting any entry already stored
+// there without consulting the function passed to WithOnConflict. It
+// returns the resulting tree and the number of moved entries.
+func (i *Iradix[T]) ReplacePrefix(oldPrefix, newPrefix []byte) (newTree *Iradix[T], moved int) {
+	n, suffix := i.findPrefix(oldPrefix)
+	if n == nil {
+		return i, 0
+	}
+
+	var suffixes [][]byte
+	var vals []T
+	iterateSubtree(slices.Clone(suffix), n, func(suffix []byte, val T) bool {
+		suffixes = append(suffixes, slices.Clone(suffix))
+		vals = append(vals, val)
+		return true
+	})
+	if bytes.Equal(oldPrefix, newPrefix) {
+		return i, len(suffixes)
+	}
+
+	// All entries are removed before any is inserted, as the new keys may
+	// fall below oldPrefix again.
+	t := i.txn()
+	for _, suffix := range suffixes {
+		t.delete(slices.Concat(oldPrefix, suffix))
+	}
+	for idx, suffix := range suffixes {
+		t.overwrite(slices.Concat(newPrefix, suffix), vals[idx])
+	}
+	return t.commit(i), len(suffixes)
+}
+
+// PopPrefix removes all entries whose key starts with prefix and returns an
+// iterator over them in key order along with the resulting tree. The removed
+// nodes are detached rather than copied, and as nodes are immutable the
+// iterator may be used at any time and more than once. Like with Iterate,
+// the yielded key aliases a buffer that is reused across iteration steps.
+func (i *Iradix[T]) PopPrefix(prefix []byte) (removed iter.Seq2[[]byte, T], newTree *Iradix[T]) {
+	t := i.singleTxn()
+	n, suffix := t.deletePrefix(prefix)
+	if n == nil {
+		return func(func([]byte, T) bool) {}, i
+	}
+
+	key := slices.Concat(prefix, suffix)
+	return func(yield func([]byte, T) bool) {
+		iterateSubtree(slices.Clone(key), n, yield)
+	}, t.commit(i)
+}
+
+// Map returns a tree with the same keys and f applied to every value. The
+// structure of the tree is copied as-is, so no insertions are needed.
+func (i *Iradix[T]) Map(f func(key []byte, val T) T) *Iradix[T] {
+	var mapNode func(key []byte, n *node[T]) *node[T]
+	mapNode = func(key []byte, n *node[T]) *node[T] {
+		key = append(key, n.path...)
+		newNode := &node[T]{path: n.path, subtreeSize: n.subtreeSize}
+		if n.val != nil {
+			newNode.val = i.cfg.storeValue(f(key, *n.val))
+		}
+		if len(n.children) > 0 {
+			newNode.children = make([]*node[T], len(n.children))
+			for idx, child := range n.children {
+				newNode.children[idx] = mapNode(key, child)
+			}
+		}
+		return newNode
+	}
+
+	return i.derive(mapNode(nil, i.root), i.len)
+}
+
+// Filter returns a tree holding only the entries for which pred returns true.
+func (i *Iradix[T]) Filter(pred func(key []byte, val T) bool) *Iradix[T] {
+	t := i.empty().txn()
+	for key, val := range i.Iterate() {
+		if pred(key, val) {
+			t.insert(key, val)
+		}
+	}
+	return t.commit(i)
+}
+
+// Split returns a tree holding all entries whose key is less than pivot and
+// one holding the remaining entries.
+func (i *Iradix[T]) Split(pivot []byte) (left, right *Iradix[T]) {
+	leftTxn, rightTxn := i.empty().txn(), i.empty().txn()
+	for key, val := range i.Iterate() {
+		if bytes.Compare(key, pivot) < 0 {
+			leftTxn.insert(key, val)
+		} else {
+			rightTxn.insert(key, val)
+		}
+	}
+	return leftTxn.commit(i), rightTxn.commit(i)
+}
+
+// Equal reports whether both trees hold the same keys with values that are
+// equal according to eq.
+func (i *Iradix[T]) Equal(other *Iradix[T], eq func(a, b T) bool) bool {
+	if i.root == other.root {
+		return true
+	}
+	if i.len != other.len {
+		return false
+	}
+
+	next, stop := iter.Pull2(other.Iterate())
+	defer stop()
+	for key, val := range i.Iterate() {
+		otherKey, otherVal, ok := next()
+		if !ok || !bytes.Equal(key, otherKey) || !eq(val, otherVal) {
+			return false
+		}
+	}
+	_, _, ok := next()
+	return !ok
+}
+
+// KeysMissingIn yields the entries of i whose key is not present in other,
+// in key order. Both trees are walked once in parallel, so it is linear in
+// their combined size. Like with Iterate, the yielded key aliases a buffer
+// that is reused across iteration steps.
+func (i *Iradix[T]) KeysMissingIn(other *Iradix[T]) iter.Seq2[[]byte, T] {
+	return func(yield func([]byte, T) bool) {
+		if i.root == other.root {
+			return
+		}
+
+		next, stop := iter.Pull2(other.Iterate())
+		defer stop()
+		otherKey, _, otherOK := next()
+		for key, val := range i.Iterate() {
+			for otherOK && bytes.Compare(otherKey, key) < 0 {
+				otherKey, _, otherOK = next()
+			}
+			if otherOK && bytes.Equal(otherKey, key) {
+				continue
+			}
+			if !yield(key, val) {
+				return
+			}
+		}
+	}
+}
+
+// Intersect returns a tree holding the keys present in both trees, with the
+// value resolve(a, b) where a is taken from i and b from other. Both trees
+// are walked once in parallel.
+func (i *Iradix[T]) Intersect(other *Iradix[T], resolve func(a, b T) T) *Iradix[T] {
+	t := i.empty().txn()
+	next, stop := iter.Pull2(other.Iterate())
+	defer stop()
+	otherKey, otherVal, otherOK := next()
+	for key, val := range i.Iterate() {
+		for otherOK && bytes.Compare(otherKey, key) < 0 {
+			otherKey, otherVal, otherOK = next()
+		}
+		if !otherOK {
+			break
+		}
+		if bytes.Equal(otherKey, key) {
+			t.insert(key, resolve(val, otherVal))
+		}
+	}
+	return t.commit(i)
+}
+
+// Keys returns all keys in lexicographic order. Every key is a fresh copy.
+func (i *Iradix[T]) Keys() [][]byte {
+	keys := make([][]byte, 0, i.len)
+	for key := range i.Iterate() {
+		keys = append(keys, slices.Clone(key))
+	}
+	return keys
+}
+
+// Values returns all values in the lexicographic order of their keys.
+func (i *Iradix[T]) Values() []T {
+	vals := make([]T, 0, i.len)
+	for _, val := range i.Iterate() {
+		vals = append(vals, val)
+	}
+	return vals
+}
+
+// ToMap returns all entries of the tree keyed by string(key).
+func (i *Iradix[T]) ToMap() map[string]T {
+	m := make(map[string]T, i.len)
+	for key, val := range i.Iterate() {
+		m[string(key)] = val
+	}
+	return m
+}
+
+// Walk calls f for every node of the tree in pre-order, visiting children in
+// the order of their first byte. Nodes that only exist to split a compressed
+// path are visited as well with hasVal set to false. If f returns false, the
+// children of the node are skipped. The key passed to f is only valid for
+// the duration of the call.
+func (i *Iradix[T]) Walk(f func(key []byte, val T, hasVal bool) (descend bool)) {
+	var walk func(key []byte, n *node[T])
+	walk = func(key []byte, n *node[T]) {
+		var val T
+		if n.val != nil {
+			val = *n.val
+		}
+		if !f(key, val, n.val != nil) {
+			return
+		}
+		for _, child := range n.children {
+			walk(append(key, child.path...), child)
+		}
+	}
+	walk(nil, i.root)
+}
+
+// WalkNodes is like Walk, but rather than keys and values it passes the
+// structure of each node to f: its own compressed path segment, its depth
+// with the root at zero, whether it holds a value and its number of
+// children. If f returns false, the children of the node are skipped. The
+// path is shared with the tree and must not be modified.
+func (i *Iradix[T]) WalkNodes(f func(path []byte, depth int, hasVal bool, numChildren int) (descend bool)) {
+	var walk func(n *node[T], depth int)
+	walk = func(n *node[T], depth int) {
+		if !f(n.path, depth, n.val != nil, len(n.children)) {
+			return
+		}
+		for _, child := range n.children {
+			walk(child, depth+1)
+		}
+	}
+	walk(i.root, 0)
+}
+
+// Clear returns an empty tree with the same configuration as i.
+func (i *Iradix[T]) Clear() *Iradix[T] {
+	return i.empty()
+}
+
+// Fold calls f for every entry of i in key order, passing the result of the
+// previous call as acc, and returns the result of the last call.
+func Fold[T, A any](i *Iradix[T], acc A, f func(acc A, key []byte, val T) A) A {
+	for key, val := range i.Iterate() {
+		acc = f(acc, key, val)
+	}
+	return acc
+}
+
+// Collect returns the result of calling f for every entry of i in key order.
+// The key passed to f is only valid for the duration of the call.
+func Collect[T, R any](i *Iradix[T], f func(key []byte, val T) R) []R {
+	results := make([]R, 0, i.len)
+	for key, val := range i.Iterate() {
+		results = append(results, f(key, val))
+	}
+	return results
+}
+
+// Append returns a tree in which vals are appended to the slice stored under
+// key, or stored as a new slice if key is absent. The result is always a
+// fresh copy, so neither the slices held by i nor vals are ever modified. If
+// vals is empty, i is returned as-is.
+func Append[E any](i *Iradix[[]E], key []byte, vals ...E) *Iradix[[]E] {
+	if len(vals) == 0 {
+		return i
+	}
+	_, newTree := i.Update(key, func(old []E, _ bool) []E {
+		return slices.Concat(old, vals)
+	})
+	return newTree
+}
+
+// derive returns a tree with the given root that shares the configuration
+// of i and succeeds it in version.
+func (i *Iradix[T]) derive(root *node[T], len int) *Iradix[T] {
+	return &Iradix[T]{root: root, len: len, cfg: i.cfg, version: i.version + 1}
+}
+
+// empty returns an empty tree that shares the configuration of i.
+func (i *Iradix[T]) empty() *Iradix[T] {
+	return i.derive(&node[T]{}, 0)
+}
+
 func (i Iradix[T]) Len() int { return i.len }
 
+// Version returns the number of changes that led from a newly created tree
+// to i. Every operation that returns a tree other than its receiver
+// increments it by one, including batch operations like InsertMany, while
+// operations that change nothing return the receiver and thus keep it.
+// Trees derived independently from the same tree may share a version.
+func (i Iradix[T]) Version() uint64 { return i.version }
+
+// IsEmpty reports whether the tree holds no entries.
+func (i Iradix[T]) IsEmpty() bool { return i.root.val == nil && len(i.root.children) == 0 }
+
+// Validate checks the structural invariants of the tree: Every node other
+// than the root has a non-empty path and either holds a value or has at
+// least two children, children are ordered by and unique in their first
+// byte, every node knows the number of values below it and Len matches the
+// number of stored values.
+func (i *Iradix[T]) Validate() error {
+	if len(i.root.path) > 0 {
+		return fmt.Errorf("root has non-empty path %q", i.root.path)
+	}
+
+	// validate returns the number of values below n.
+	var validate func(key []byte, n *node[T]) (int, error)
+	validate = func(key []byte, n *node[T]) (int, error) {
+		if n != i.root {
+			if len(n.path) == 0 {
+				return 0, fmt.Errorf("node below %q has an empty path", key)
+			}
+			key = append(key, n.path...)
+			if n.val == nil && len(n.children) < 2 {
+				return 0, fmt.Errorf("node %q has no value and %d children", key, len(n.children))
+			}
+		}
+		values := 0
+		if n.val != nil {
+			values++
+		}
+
+		for idx, child := range n.children {
+			childValues, err := validate(slices.Clone(key), child)
+			if err != nil {
+				return 0, err
+			}
+			values += childValues
+			if idx > 0 && n.children[idx-1].path[0] >= child.path[0] {
+				return 0, fmt.Errorf("children of node %q are not strictly ordered by first byte: %q >= %q", key, n.children[idx-1].path[0], child.path[0])
+			}
+		}
+		if values != n.subtreeSize {
+			return 0, fmt.Errorf("node %q has subtree size %d but holds %d values", key, n.subtreeSize, values)
+		}
+		return values, nil
+	}
+	values, err := validate(nil, i.root)
+	if err != nil {
+		return err
+	}
+
+	if values != i.len {
+		return fmt.Errorf("tree has length %d but holds %d values", i.len, values)
+	}
+	return nil
+}
+
 type node[T any] struct {
 	path     []byte
 	val      *T
 	children []*node[T]
+	// subtreeSize is the number of values stored in the subtree rooted at
+	// the node, including its own.
+	subtreeSize int
+	// mutateCh is created on demand by watch and closed once the node
+	// gets superseded by a copy.
+	mutateCh atomic.Pointer[chan struct{}]
+}
+
+// closedCh marks nodes that have been superseded before anyone watched them.
+var closedCh = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// watch returns a channel that is closed when n gets superseded by a copy.
+func (n *node[T]) watch() <-chan struct{} {
+	if ch := n.mutateCh.Load(); ch != nil {
+		return *ch
+	}
+	ch := make(chan struct{})
+	if n.mutateCh.CompareAndSwap(nil, &ch) {
+		return ch
+	}
+	return *n.mutateCh.Load()
+}
+
+// notify closes the watch channel of n, if any, and makes sure watches
+// obtained afterwards are closed right away.
+func (n *node[T]) notify() {
+	if ch := n.mutateCh.Swap(&closedCh); ch != nil && ch != &closedCh {
+		close(*ch)
+	}
+}
+
+// notifySubtree notifies n and all of its descendants.
+func (n *node[T]) notifySubtree() {
+	n.notify()
+	for _, child := range n.children {
+		child.notifySubtree()
+	}
 }
 
 func copyNode[T any](n *node[T]) *node[T] {
 	return &node[T]{
-		path:     n.path,
-		val:      n.val,
-		children: slices.Clone(n.children),
+		path:        n.path,
+		val:         n.val,
+		children:    slices.Clone(n.children),
+		subtreeSize: n.subtreeSize,
+	}
+}
+
+// iterateSubtree yields the entries below n in order, where key is the key
+// of n. It returns false if yield did.
+func iterateSubtree[T any](key []byte, n *node[T], yield func([]byte, T) bool) bool {
+	if n.val != nil && !yield(key, *n.val) {
+		return false
+	}
+	for _, child := range n.children {
+		if !iterateSubtree(append(key, child.path...), child, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// reverseIterateSubtree is like iterateSubtree but yields the entries in
+// descending key order.
+func reverseIterateSubtree[T any](key []byte, n *node[T], yield func([]byte, T) bool) bool {
+	for _, child := range slices.Backward(n.children) {
+		if !reverseIterateSubtree(append(key, child.path...), child, yield) {
+			return false
+		}
 	}
+	return n.val == nil || yield(key, *n.val)
 }
 
 func commonPrefixLen(a, b []byte) int {
@@ -1,18 +1,80 @@
 package iradix
 
 import (
+	"bytes"
 	"fmt"
+	"iter"
+	"maps"
+	"math/rand/v2"
 	"reflect"
 	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// spewConfig dumps without calling String methods, so that dumps include
+// pointers and reveal any mutation of shared nodes.
+var spewConfig = spew.ConfigState{Indent: " ", DisableMethods: true}
+
+type dumpedTree[T any] struct {
+	Len  int
+	Root dumpedNode[T]
+}
+
+type dumpedNode[T any] struct {
+	Addr        string
+	Path        []byte
+	Val         *T
+	SubtreeSize int
+	Children    []dumpedNode[T]
+}
+
+// testEntries returns the entries most tests build their tree from, minus
+// the keys in without. They cover the empty key, keys that are prefixes of
+// others, a split path and a sibling sharing all but its last byte.
+func testEntries(without ...string) map[string]string {
+	entries := map[string]string{
+		"":                        "empty-val",
+		"namespace":               "namespace-val",
+		"namespace/pod-1":         "pod-1-val",
+		"namespace/pod-2/owner-1": "owner-1-val",
+		"namespace/pod-2/owner-2": "owner-2-val",
+		"namespaces":              "namespaces-val",
+	}
+	for _, key := range without {
+		delete(entries, key)
+	}
+	return entries
+}
+
+// newTestTree returns a tree holding testEntries(without...).
+func newTestTree(without ...string) *Iradix[string] {
+	return NewFromMap(testEntries(without...))
+}
+
+// dumpTree renders a tree for comparison in tests. It includes the node
+// addresses to detect copies but not the watch state of the nodes, as that
+// is expected to change when a node gets superseded by a copy.
+func dumpTree[T any](tree *Iradix[T]) string {
+	var dumpNode func(n *node[T]) dumpedNode[T]
+	dumpNode = func(n *node[T]) dumpedNode[T] {
+		dumped := dumpedNode[T]{Addr: fmt.Sprintf("%p", n), Path: n.path, Val: n.val, SubtreeSize: n.subtreeSize}
+		for _, child := range n.children {
+			dumped.Children = append(dumped.Children, dumpNode(child))
+		}
+		return dumped
+	}
+
+	return spewConfig.Sdump(dumpedTree[T]{Len: tree.len, Root: dumpNode(tree.root)})
+}
+
 type testItem struct {
 	key    []byte
 	val    string
@@ -161,6 +223,9 @@ func TestIradixInsertGetDelete(t *testing.T) {
 			// Delete of items in empty tree
 			tree = validateDelete(t, tree, false, tc.items...)
 
+			// The resulting tree must not depend on the insertion order
+			validateShuffledInserts(t, tree, tc.items)
+
 			// Item by item create->get->delete
 			for _, item := range tc.items {
 				tree = validateInsert(t, tree, item)
@@ -187,6 +252,7 @@ func TestIradixInsertGetDelete(t *testing.T) {
 				val, exists := tree.Get(item.key)
 				require.True(t, exists)
 				require.Equal(t, item.val, val)
+				require.True(t, tree.Contains(item.key))
 			}
 
 			idx := 0
@@ -199,37 +265,49 @@ func TestIradixInsertGetDelete(t *testing.T) {
 				idx++
 			}
 
+			require.False(t, tree.IsEmpty())
 			tree = validateDelete(t, tree, true, tc.items...)
 			require.Equal(t, 0, tree.Len())
+			require.True(t, tree.IsEmpty())
 		})
 	}
 }
 
 func validateTree[T any](t *testing.T, tree *Iradix[T]) {
 	t.Helper()
-	if tree.root == nil {
-		return
+	if err := tree.Validate(); err != nil {
+		t.Errorf("invalid tree: %v\ntree: %s", err, dumpTree(tree))
 	}
-	var iterate func(n *node[T], parents []*node[T])
-	iterate = func(n *node[T], parents []*node[T]) {
-		t.Helper()
-		if n.val == nil && len(n.children) < 2 && n != tree.root {
-			t.Errorf("found empty node, parents: %+v", parents)
-		}
-		seenChildKeys := map[byte]struct{}{}
-		for _, child := range n.children {
-			iterate(child, append(parents, n))
-			if len(child.path) > 0 {
-				_, seen := seenChildKeys[child.path[0]]
-				if seen {
-					t.Errorf("found two children with first byte %v", child.path[0])
-				}
-				seenChildKeys[child.path[0]] = struct{}{}
-			}
+}
+
+// validateShuffledInserts inserts items into tree in several random orders
+// and verifies that every order yields a valid tree that is equal to the one
+// from inserting them in the given order, down to its structure.
+func validateShuffledInserts(t *testing.T, tree *Iradix[string], items []testItem) {
+	t.Helper()
+	insertAll := func(items []testItem) *Iradix[string] {
+		result := tree
+		for _, item := range items {
+			_, _, result = result.Insert(item.key, item.val)
+			validateTree(t, result)
 		}
+		return result
 	}
 
-	iterate(tree.root, nil)
+	expected := insertAll(items)
+	rng := rand.New(rand.NewPCG(7, 8))
+	for range 10 {
+		shuffled := slices.Clone(items)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		var order []string
+		for _, item := range shuffled {
+			order = append(order, string(item.key))
+		}
+
+		actual := insertAll(shuffled)
+		require.True(t, expected.Equal(actual, func(a, b string) bool { return a == b }), "insertion order %q", order)
+		require.Equal(t, expected.String(), actual.String(), "insertion order %q", order)
+	}
 }
 
 func validateInsert(t *testing.T, tree *Iradix[string], items ...testItem) *Iradix[string] {
@@ -237,9 +315,9 @@ func validateInsert(t *testing.T, tree *Iradix[string], items ...testItem) *Irad
 	oldVal, existed := "", false
 	for idx, item := range items {
 		originalTree := tree
-		originalTreeDump := spew.Sdump(tree)
+		originalTreeDump := dumpTree(tree)
 		oldVal, existed, tree = tree.Insert(item.key, item.val)
-		newTree := spew.Sdump(tree)
+		newTree := dumpTree(tree)
 		validateTree(t, tree)
 		require.Equal(t,
 			item.oldVal != "",
@@ -248,7 +326,7 @@ func validateInsert(t *testing.T, tree *Iradix[string], items ...testItem) *Irad
 		)
 		require.Equal(t, item.oldVal != "", existed)
 		require.Equal(t, item.oldVal, oldVal)
-		require.Equal(t, originalTreeDump, spew.Sdump(originalTree), "original tree should be unmodified")
+		require.Equal(t, originalTreeDump, dumpTree(originalTree), "original tree should be unmodified")
 
 		validateDelete(t, tree, false, items[idx+1:]...)
 	}
@@ -260,10 +338,10 @@ func validateDelete(t *testing.T, tree *Iradix[string], expectPresent bool, item
 	t.Helper()
 	oldVal, existed := "", false
 	for _, item := range items {
-		originalTree := spew.Sdump(tree)
+		originalTree := dumpTree(tree)
 		oldVal, existed, tree = tree.Delete(item.key)
 		validateTree(t, tree)
-		newTree := spew.Sdump(tree)
+		newTree := dumpTree(tree)
 		require.Equal(t,
 			expectPresent,
 			existed,
@@ -279,6 +357,7 @@ func validateDelete(t *testing.T, tree *Iradix[string], expectPresent bool, item
 
 		_, exists := tree.Get(item.key)
 		require.False(t, exists, "deleted item %s still exists", item.key)
+		require.False(t, tree.Contains(item.key), "deleted item %s still exists", item.key)
 	}
 
 	return tree
@@ -328,7 +407,11 @@ func TestPathCompressionUpdates(t *testing.T) {
 			}
 
 			tree = validateInsert(t, tree, tc.update)
-			require.Equal(t, len(tc.setup)+1, tree.Len())
+			expectedLen := len(tc.setup) + 1
+			if tc.update.oldVal != "" {
+				expectedLen = len(tc.setup)
+			}
+			require.Equal(t, expectedLen, tree.Len())
 
 			val, exists := tree.Get(tc.update.key)
 			require.True(t, exists)
@@ -348,6 +431,109 @@ func TestPathCompressionUpdates(t *testing.T) {
 	}
 }
 
+func TestInsertSplitsIntoValuedNode(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		setup  []string
+		insert string
+		expect string
+	}{
+		{
+			name:   "Key ends at shared segment boundary",
+			setup:  []string{"namespace/pod-1", "namespace/pod-2"},
+			insert: "namespace",
+			expect: `"" = 0
+└── "namespace" = 1
+    └── "/pod-"
+        ├── "1" = 1
+        └── "2" = 1
+`,
+		},
+		{
+			name:   "Key ends within shared segment",
+			setup:  []string{"namespace/pod-1", "namespace/pod-2"},
+			insert: "name",
+			expect: `"" = 0
+└── "name" = 1
+    └── "space/pod-"
+        ├── "1" = 1
+        └── "2" = 1
+`,
+		},
+		{
+			name:   "Split below non-root node",
+			setup:  []string{"ns/owner-a/x", "ns/owner-a/y", "ns/other"},
+			insert: "ns/owner",
+			expect: `"" = 0
+└── "ns/o"
+    ├── "ther" = 1
+    └── "wner" = 1
+        └── "-a/"
+            ├── "x" = 1
+            └── "y" = 1
+`,
+		},
+		{
+			name:   "Split of segment with valued node below",
+			setup:  []string{"namespace/pod", "namespace/pod-1", "namespace/pod-2"},
+			insert: "namespace/p",
+			expect: `"" = 0
+└── "namespace/p" = 1
+    └── "od" = 1
+        └── "-"
+            ├── "1" = 1
+            └── "2" = 1
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			setup := map[string]int{"": 0}
+			for _, key := range tc.setup {
+				setup[key] = 1
+			}
+			tree := NewFromMap(setup)
+			originalTreeDump := dumpTree(tree)
+			snapshot := snapshotNodes(tree)
+
+			_, existed, inserted := tree.Insert([]byte(tc.insert), 1)
+			require.False(t, existed)
+			validateTree(t, inserted)
+			require.Equal(t, tc.expect, inserted.String())
+			require.Equal(t, len(tc.setup)+2, inserted.Len())
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+			requireNodesUnchanged(t, snapshot)
+
+			// The split child only gets its path shortened, everything
+			// below it is shared with the original tree.
+			for _, key := range tc.setup {
+				ref, ok := tree.GetRef([]byte(key))
+				require.True(t, ok)
+				newRef, ok := inserted.GetRef([]byte(key))
+				require.True(t, ok)
+				require.Same(t, ref, newRef, "key %q", key)
+			}
+
+			// A batch building the whole tree results in the same one.
+			batch := New[int]().InsertMany(func(yield func([]byte, int) bool) {
+				for _, key := range append([]string{""}, tc.setup...) {
+					if !yield([]byte(key), setup[key]) {
+						return
+					}
+				}
+				yield([]byte(tc.insert), 1)
+			})
+			validateTree(t, batch)
+			require.Equal(t, tc.expect, batch.String())
+		})
+	}
+}
+
 func TestPathCompressionDeletion(t *testing.T) {
 	t.Parallel()
 
@@ -424,92 +610,2888 @@ func TestPathCompressionDeletion(t *testing.T) {
 	}
 }
 
-func TestParallelInsertGet(t *testing.T) {
+func TestGetOrInsert(t *testing.T) {
 	t.Parallel()
-	tree := New[string]()
 
-	wg := sync.WaitGroup{}
-	wg.Add(3)
+	testCases := []struct {
+		name         string
+		setup        []testItem
+		key          []byte
+		val          string
+		expectActual string
+		expectLoaded bool
+	}{
+		{
+			name:         "Empty tree",
+			key:          []byte("foo"),
+			val:          "foo-val",
+			expectActual: "foo-val",
+		},
+		{
+			name:         "Empty key",
+			key:          nil,
+			val:          "empty-val",
+			expectActual: "empty-val",
+		},
+		{
+			name: "Existing key",
+			setup: []testItem{
+				{key: []byte("foo"), val: "foo-val"},
+			},
+			key:          []byte("foo"),
+			val:          "other-val",
+			expectActual: "foo-val",
+			expectLoaded: true,
+		},
+		{
+			name: "Key splits compressed path",
+			setup: []testItem{
+				{key: []byte("foobar"), val: "foobar-val"},
+			},
+			key:          []byte("foo"),
+			val:          "foo-val",
+			expectActual: "foo-val",
+		},
+	}
 
-	go func() {
-		tree.Insert([]byte("foo"), "something")
-		wg.Done()
-	}()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
 
-	go func() {
-		tree.Delete([]byte("foo"))
-		wg.Done()
-	}()
+			tree := validateInsert(t, New[string](), tc.setup...)
+			originalTreeDump := dumpTree(tree)
 
-	go func() {
-		tree.Get([]byte("foo"))
-		wg.Done()
-	}()
+			actual, loaded, newTree := tree.GetOrInsert(tc.key, tc.val)
+			validateTree(t, newTree)
+			require.Equal(t, tc.expectActual, actual)
+			require.Equal(t, tc.expectLoaded, loaded)
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+
+			if tc.expectLoaded {
+				require.Same(t, tree, newTree)
+				return
+			}
+
+			require.Equal(t, tree.Len()+1, newTree.Len())
+			val, exists := newTree.Get(tc.key)
+			require.True(t, exists)
+			require.Equal(t, tc.val, val)
+		})
+	}
 }
 
-func TestParallelInsertDelete(t *testing.T) {
+func TestVersion(t *testing.T) {
 	t.Parallel()
+
 	tree := New[string]()
-	_, _, tree = tree.Insert([]byte("foo"), "something")
+	require.Zero(t, tree.Version())
 
-	wg := sync.WaitGroup{}
-	wg.Add(2)
+	_, _, tree = tree.Insert([]byte("foo"), "foo-val")
+	require.Equal(t, uint64(1), tree.Version())
+	_, _, tree = tree.Insert([]byte("foo"), "other-val")
+	require.Equal(t, uint64(2), tree.Version())
+	_, _, tree = tree.Insert([]byte("foobar"), "foobar-val")
+	require.Equal(t, uint64(3), tree.Version())
+	_, _, tree = tree.Delete([]byte("foo"))
+	require.Equal(t, uint64(4), tree.Version())
 
-	go func() {
-		tree.Delete([]byte("foo"))
-		wg.Done()
-	}()
+	_, _, noop := tree.Delete([]byte("foo"))
+	require.Equal(t, tree.Version(), noop.Version(), "deleting a missing key must keep the version")
+	_, _, noop = tree.GetOrInsert([]byte("foobar"), "other-val")
+	require.Equal(t, tree.Version(), noop.Version(), "loading an existing key must keep the version")
 
-	go func() {
-		tree.Get([]byte("foo"))
-		wg.Done()
-	}()
+	comparable := NewWithOptions(WithComparable[string]())
+	_, _, comparable = comparable.Insert([]byte("foo"), "foo-val")
+	_, _, noop = comparable.Insert([]byte("foo"), "foo-val")
+	require.Equal(t, comparable.Version(), noop.Version(), "inserting an equal value must keep the version")
+
+	batch := tree.InsertMany(func(yield func([]byte, string) bool) {
+		for _, key := range []string{"a", "b", "c"} {
+			if !yield([]byte(key), key+"-val") {
+				return
+			}
+		}
+	})
+	require.Equal(t, tree.Version()+1, batch.Version(), "a batch counts as a single change")
 }
 
-func BenchmarkIradixWriteRead(b *testing.B) {
-	const value = "the value we store"
-	for i := 0; i < b.N; i++ {
-		tree := New[string]()
+func TestGetOrInsertFunc(t *testing.T) {
+	t.Parallel()
 
-		for cycle := 0; cycle < 10; cycle++ {
-			// Insert 100 elements with common prefix
-			cycle := 1
-			prefix := fmt.Sprintf("prefix%d/", cycle)
-			for j := 0; j < 100; j++ {
-				key := []byte(prefix + strconv.Itoa(j))
-				_, _, tree = tree.Insert(key, value)
-			}
+	tree := NewFromMap(map[string]string{"foobar": "foobar-val"})
+	originalTreeDump := dumpTree(tree)
 
-			// Read 100 elements 3 times with different prefixes
-			readPrefixes := []string{
-				"prefix" + strconv.Itoa(max(0, cycle-2)) + "_",
-				"prefix" + strconv.Itoa(max(0, cycle-1)) + "_",
-				"prefix" + strconv.Itoa(cycle) + "_",
-			}
+	calls := 0
+	newVal := func() string {
+		calls++
+		return "foo-val"
+	}
 
-			for _, readPrefix := range readPrefixes {
-				for j := 0; j < 100; j++ {
-					key := []byte(readPrefix + strconv.Itoa(j))
-					tree.Get(key)
+	actual, loaded, newTree := tree.GetOrInsertFunc([]byte("foobar"), newVal)
+	require.True(t, loaded)
+	require.Equal(t, "foobar-val", actual)
+	require.Same(t, tree, newTree)
+	require.Zero(t, calls, "f must not be called for a present key")
+
+	actual, loaded, newTree = tree.GetOrInsertFunc([]byte("foo"), newVal)
+	require.False(t, loaded)
+	require.Equal(t, "foo-val", actual)
+	require.Equal(t, 1, calls)
+	validateTree(t, newTree)
+	require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+	require.Equal(t, map[string]string{"foo": "foo-val", "foobar": "foobar-val"}, newTree.ToMap())
+}
+
+func TestInsertIfAbsent(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{"foobar": "foobar-val"})
+	originalTreeDump := dumpTree(tree)
+
+	current, inserted, newTree := tree.InsertIfAbsent([]byte("foo"), "foo-val")
+	require.True(t, inserted)
+	require.Equal(t, "foo-val", current)
+	validateTree(t, newTree)
+	require.Equal(t, 2, newTree.Len())
+	require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+
+	current, inserted, sameTree := newTree.InsertIfAbsent([]byte("foobar"), "other-val")
+	require.False(t, inserted)
+	require.Equal(t, "foobar-val", current)
+	require.Same(t, newTree, sameTree)
+}
+
+func TestReplace(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{
+		"namespace":       "namespace-val",
+		"namespace/pod-1": "pod-1-val",
+	})
+	originalTreeDump := dumpTree(tree)
+
+	oldVal, replaced, newTree := tree.Replace([]byte("namespace/pod-1"), "new-val")
+	require.True(t, replaced)
+	require.Equal(t, "pod-1-val", oldVal)
+	validateTree(t, newTree)
+	require.Equal(t, map[string]string{"namespace": "namespace-val", "namespace/pod-1": "new-val"}, newTree.ToMap())
+	require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+
+	for _, key := range []string{"namespace/pod-2", "namespace/pod-", "name", ""} {
+		oldVal, replaced, sameTree := tree.Replace([]byte(key), "new-val")
+		require.False(t, replaced, "key %q", key)
+		require.Zero(t, oldVal, "key %q", key)
+		require.Same(t, tree, sameTree, "key %q", key)
+	}
+
+	oldVal, replaced, sameTree := tree.Replace([]byte("namespace"), "namespace-val")
+	require.True(t, replaced)
+	require.Equal(t, "namespace-val", oldVal)
+	require.Same(t, tree, sameTree, "replacing with an identical value should not change the tree")
+
+	sum := NewWithOptions(WithOnConflict(func(old, new int) int { return old + new }))
+	_, _, sum = sum.Insert([]byte("counter"), 1)
+	oldCount, replaced, sum := sum.Replace([]byte("counter"), 5)
+	require.True(t, replaced)
+	require.Equal(t, 1, oldCount)
+	count, _ := sum.Get([]byte("counter"))
+	require.Equal(t, 5, count, "Replace should not consult onConflict")
+
+	_, _, limited := NewWithOptions(WithMaxKeyLen[string](4)).Insert([]byte("key"), "val")
+	require.NotPanics(t, func() {
+		oldVal, replaced, sameTree = limited.Replace([]byte("too-long"), "new-val")
+	})
+	require.False(t, replaced)
+	require.Zero(t, oldVal)
+	require.Same(t, limited, sameTree)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]int{"counter": 1})
+	eq := func(a, b int) bool { return a == b }
+
+	swapped, sameTree := tree.CompareAndSwap([]byte("counter"), 2, 3, eq)
+	require.False(t, swapped)
+	require.Same(t, tree, sameTree)
+
+	swapped, sameTree = tree.CompareAndSwap([]byte("count"), 0, 3, eq)
+	require.False(t, swapped)
+	require.Same(t, tree, sameTree)
+
+	swapped, newTree := tree.CompareAndSwap([]byte("counter"), 1, 2, eq)
+	require.True(t, swapped)
+	validateTree(t, newTree)
+	require.Equal(t, map[string]int{"counter": 2}, newTree.ToMap())
+	require.Equal(t, map[string]int{"counter": 1}, tree.ToMap(), "original tree should be unmodified")
+}
+
+func TestInsertChanged(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]int{"counter": 1})
+	eq := func(a, b int) bool { return a == b }
+
+	oldVal, changed, sameTree := tree.InsertChanged([]byte("counter"), 1, eq)
+	require.False(t, changed)
+	require.Equal(t, 1, oldVal)
+	require.Same(t, tree, sameTree)
+
+	oldVal, changed, newTree := tree.InsertChanged([]byte("counter"), 2, eq)
+	require.True(t, changed)
+	require.Equal(t, 1, oldVal)
+	validateTree(t, newTree)
+	require.Equal(t, map[string]int{"counter": 2}, newTree.ToMap())
+
+	oldVal, changed, newTree = tree.InsertChanged([]byte("count"), 0, eq)
+	require.True(t, changed, "inserting a new key is a change even for the zero value")
+	require.Zero(t, oldVal)
+	validateTree(t, newTree)
+	require.Equal(t, map[string]int{"count": 0, "counter": 1}, newTree.ToMap())
+	require.Equal(t, map[string]int{"counter": 1}, tree.ToMap(), "original tree should be unmodified")
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	increment := func(old int, existed bool) int {
+		if !existed {
+			return 1
+		}
+		return old + 1
+	}
+
+	tree := New[int]()
+	for range 3 {
+		for _, key := range []string{"counter", "count", "", "other"} {
+			_, tree = tree.Update([]byte(key), increment)
+			validateTree(t, tree)
+		}
+	}
+
+	require.Equal(t, 4, tree.Len())
+	for _, key := range []string{"counter", "count", "", "other"} {
+		val, exists := tree.Get([]byte(key))
+		require.True(t, exists)
+		require.Equal(t, 3, val)
+	}
+
+	originalTreeDump := dumpTree(tree)
+	newVal, newTree := tree.Update([]byte("co"), increment)
+	require.Equal(t, 1, newVal)
+	require.Equal(t, 5, newTree.Len())
+	require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+}
+
+func TestBuildSorted(t *testing.T) {
+	t.Parallel()
+
+	pairsOf := func(keys ...string) iter.Seq2[[]byte, string] {
+		return func(yield func([]byte, string) bool) {
+			for _, key := range keys {
+				if !yield([]byte(key), key+"-val") {
+					return
 				}
 			}
 		}
 	}
-}
 
-func BenchmarkIterate(b *testing.B) {
-	const value = "the value we store"
-	tree := New[string]()
-	for i := range 100 {
-		for j := range 1000 {
-			tree.Insert([]byte(fmt.Sprintf("prefix%d/%d", i, j)), value)
+	testCases := []struct {
+		name        string
+		keys        []string
+		expectedErr string
+	}{
+		{name: "Empty input"},
+		{name: "Empty key only", keys: []string{""}},
+		{
+			name: "Splits and prefixes",
+			keys: []string{
+				"", "namespace", "namespace/pod-1", "namespace/pod-2/owner-1",
+				"namespace/pod-2/owner-2", "namespaces", "other", "others/a",
+			},
+		},
+		{name: "Split above several nodes", keys: []string{"abcd", "abcdef", "abcdeg", "abx", "b"}},
+		{name: "Binary keys", keys: []string{"\x00", "\x00\x00", "\x00\xff", "\xff"}},
+		{name: "Unsorted keys", keys: []string{"a", "c", "b"}, expectedErr: `key "b" is not greater than the previous key "c"`},
+		{name: "Duplicate keys", keys: []string{"a", "b", "b"}, expectedErr: `key "b" is not greater than the previous key "b"`},
+		{name: "Duplicate empty key", keys: []string{"", ""}, expectedErr: `key "" is not greater than the previous key ""`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tree, err := BuildSorted(pairsOf(tc.keys...))
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			validateTree(t, tree)
+
+			expected := New[string]().InsertMany(pairsOf(tc.keys...))
+			require.Equal(t, len(tc.keys), tree.Len())
+			require.Equal(t, expected.String(), tree.String())
+		})
+	}
+
+	rng := rand.New(rand.NewPCG(5, 6))
+	keys := map[string]struct{}{}
+	for range 1000 {
+		key := make([]byte, rng.IntN(8))
+		for idx := range key {
+			key[idx] = "ab/c"[rng.IntN(4)]
 		}
+		keys[string(key)] = struct{}{}
 	}
+	sortedKeys := slices.Sorted(maps.Keys(keys))
+	tree, err := BuildSorted(pairsOf(sortedKeys...))
+	require.NoError(t, err)
+	validateTree(t, tree)
+	require.Equal(t, New[string]().InsertMany(pairsOf(sortedKeys...)).String(), tree.String())
+}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		for k, v := range tree.Iterate() {
-			_, _ = k, v
+func TestInsertMany(t *testing.T) {
+	t.Parallel()
+
+	items := []testItem{
+		{key: nil, val: "empty-val"},
+		{key: []byte("namespace"), val: "namespace-val"},
+		{key: []byte("namespace/pod-1"), val: "pod-1-val"},
+		{key: []byte("namespace/pod-2/owner-1"), val: "owner-1-val"},
+		{key: []byte("namespace/pod-2/owner-2"), val: "owner-2-val"},
+		{key: []byte("namespaces"), val: "namespaces-val"},
+	}
+
+	tree := validateInsert(t, New[string](), items[:2]...)
+	originalTreeDump := dumpTree(tree)
+
+	// Insert in reverse to also exercise splits of nodes created by
+	// the same transaction.
+	pairs := func(yield func([]byte, string) bool) {
+		for _, item := range slices.Backward(items) {
+			if !yield(item.key, item.val) {
+				return
+			}
 		}
 	}
+	newTree := tree.InsertMany(pairs)
+	validateTree(t, newTree)
+	require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+	require.Equal(t, len(items), newTree.Len())
+
+	idx := 0
+	for k, v := range newTree.Iterate() {
+		require.Equal(t, items[idx].key, k)
+		require.Equal(t, items[idx].val, v)
+		idx++
+	}
+	require.Equal(t, len(items), idx)
+
+	require.Same(t, newTree, newTree.InsertMany(pairs), "inserting identical values should not change the tree")
+}
+
+func TestMapConversion(t *testing.T) {
+	t.Parallel()
+
+	m := map[string]string{
+		"":                        "empty-val",
+		"namespace":               "namespace-val",
+		"namespace/pod-1":         "pod-1-val",
+		"namespace/pod-2/owner-1": "owner-1-val",
+		"namespace/pod-2/owner-2": "owner-2-val",
+		"namespaces":              "namespaces-val",
+	}
+
+	tree := NewFromMap(m)
+	validateTree(t, tree)
+	require.Equal(t, len(m), tree.Len())
+	require.Equal(t, m, tree.ToMap())
+
+	require.Empty(t, NewFromMap(map[string]string{}).ToMap())
+}
+
+func TestKeysMissingIn(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree("namespace/pod-2/owner-2")
+
+	testCases := []struct {
+		name   string
+		other  *Iradix[string]
+		expect []string
+	}{
+		{
+			name:   "Same tree",
+			other:  tree,
+			expect: nil,
+		},
+		{
+			name:   "Empty other",
+			other:  New[string](),
+			expect: []string{"", "namespace", "namespace/pod-1", "namespace/pod-2/owner-1", "namespaces"},
+		},
+		{
+			name: "Overlapping trees",
+			other: NewFromMap(map[string]string{
+				"":                "other-val",
+				"a":               "a-val",
+				"namespace/pod-1": "other-val",
+				"namespace/pod-2": "other-val",
+				"z":               "z-val",
+			}),
+			expect: []string{"namespace", "namespace/pod-2/owner-1", "namespaces"},
+		},
+		{
+			name:   "Other is a superset",
+			other:  mustInsert(t, tree, "other", "other-val"),
+			expect: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got []string
+			for key, val := range tree.KeysMissingIn(tc.other) {
+				expectVal, _ := tree.Get(key)
+				require.Equal(t, expectVal, val)
+				got = append(got, string(key))
+			}
+			require.Equal(t, tc.expect, got)
+		})
+	}
+
+	// Stopping early must release the iteration over other.
+	for range tree.KeysMissingIn(New[string]()) {
+		break
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]int{
+		"":                        1,
+		"namespace":               2,
+		"namespace/pod-1":         3,
+		"namespace/pod-2/owner-1": 4,
+		"namespaces":              5,
+	})
+	sum := func(a, b int) int { return a + b }
+
+	testCases := []struct {
+		name   string
+		other  *Iradix[int]
+		expect map[string]int
+	}{
+		{
+			name:   "Disjoint trees",
+			other:  NewFromMap(map[string]int{"a": 1, "namespace/": 2, "namespace/pod-2": 3, "z": 4}),
+			expect: map[string]int{},
+		},
+		{
+			name:   "Empty other",
+			other:  New[int](),
+			expect: map[string]int{},
+		},
+		{
+			name:   "Identical trees",
+			other:  tree,
+			expect: map[string]int{"": 2, "namespace": 4, "namespace/pod-1": 6, "namespace/pod-2/owner-1": 8, "namespaces": 10},
+		},
+		{
+			name:   "Overlapping trees",
+			other:  NewFromMap(map[string]int{"": 10, "a": 10, "namespace/pod-1": 10, "namespaces": 10, "z": 10}),
+			expect: map[string]int{"": 11, "namespace/pod-1": 13, "namespaces": 15},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			originalTreeDump := dumpTree(tree)
+			intersection := tree.Intersect(tc.other, sum)
+			validateTree(t, intersection)
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+			require.Equal(t, tc.expect, intersection.ToMap())
+
+			// The intersection is symmetric up to the order of resolve.
+			require.Equal(t, tc.expect, tc.other.Intersect(tree, sum).ToMap())
+		})
+	}
+}
+
+func TestKeysValues(t *testing.T) {
+	t.Parallel()
+
+	tree := New[string]()
+	require.Empty(t, tree.Keys())
+	require.Empty(t, tree.Values())
+
+	tree = tree.InsertMany(func(yield func([]byte, string) bool) {
+		for _, key := range []string{"foo", "", "bar", "foobar", "fo"} {
+			if !yield([]byte(key), key+"-val") {
+				return
+			}
+		}
+	})
+
+	keys := tree.Keys()
+	require.Equal(t, [][]byte{nil, []byte("bar"), []byte("fo"), []byte("foo"), []byte("foobar")}, keys)
+	require.Equal(t, []string{"-val", "bar-val", "fo-val", "foo-val", "foobar-val"}, tree.Values())
+
+	var unsafeKeys [][]byte
+	for key := range tree.IterateUnsafe() {
+		unsafeKeys = append(unsafeKeys, slices.Clone(key))
+	}
+	require.Equal(t, keys, unsafeKeys)
+
+	for _, key := range keys {
+		for idx := range key {
+			key[idx] = 'x'
+		}
+	}
+	require.Equal(t, [][]byte{nil, []byte("bar"), []byte("fo"), []byte("foo"), []byte("foobar")}, tree.Keys(), "mutating returned keys must not affect the tree")
+}
+
+func TestIterateGroups(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{
+		"":                        "empty-val",
+		"namespace":               "namespace-val",
+		"namespace!":              "namespace!-val",
+		"namespace/pod-1":         "pod-1-val",
+		"namespace/pod-2/owner-1": "owner-1-val",
+		"namespace/pod-2/owner-2": "owner-2-val",
+		"namespaces/pod-1":        "namespaces-pod-1-val",
+		"other/":                  "other-val",
+	})
+
+	type group struct {
+		prefix string
+		keys   []string
+	}
+	var groups []group
+	var iterators []iter.Seq2[[]byte, string]
+	for prefix, entries := range tree.IterateGroups('/') {
+		g := group{prefix: string(prefix)}
+		for key, val := range entries {
+			expectVal, _ := tree.Get(key)
+			require.Equal(t, expectVal, val)
+			g.keys = append(g.keys, string(key))
+		}
+		groups = append(groups, g)
+		iterators = append(iterators, entries)
+	}
+	require.Equal(t, []group{
+		{prefix: "", keys: []string{""}},
+		{prefix: "namespace", keys: []string{"namespace"}},
+		{prefix: "namespace!", keys: []string{"namespace!"}},
+		{prefix: "namespace/", keys: []string{"namespace/pod-1", "namespace/pod-2/owner-1", "namespace/pod-2/owner-2"}},
+		{prefix: "namespaces/", keys: []string{"namespaces/pod-1"}},
+		{prefix: "other/", keys: []string{"other/"}},
+	}, groups)
+
+	// Group iterators stay valid after the outer iteration advanced.
+	var keys []string
+	for key := range iterators[3] {
+		keys = append(keys, string(key))
+	}
+	require.Equal(t, groups[3].keys, keys)
+
+	for range New[string]().IterateGroups('/') {
+		t.Fatal("empty tree should have no groups")
+	}
+}
+
+func TestIterateLeaves(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	var keys []string
+	for key, val := range tree.IterateLeaves() {
+		require.Equal(t, tree.ToMap()[string(key)], val)
+		keys = append(keys, string(key))
+	}
+	require.Equal(t, []string{"namespace/pod-1", "namespace/pod-2/owner-1", "namespace/pod-2/owner-2", "namespaces"}, keys)
+
+	keys = nil
+	for key := range NewFromMap(map[string]string{"": "empty-val"}).IterateLeaves() {
+		keys = append(keys, string(key))
+	}
+	require.Equal(t, []string{""}, keys, "a root without children is a leaf")
+
+	for range New[string]().IterateLeaves() {
+		t.Fatal("empty tree should have no leaves")
+	}
+}
+
+func TestIterateWithDepth(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	expect := []Entry[string]{
+		{Key: nil, Val: "empty-val", Depth: 0},
+		{Key: []byte("namespace"), Val: "namespace-val", Depth: 1},
+		{Key: []byte("namespace/pod-1"), Val: "pod-1-val", Depth: 3},
+		{Key: []byte("namespace/pod-2/owner-1"), Val: "owner-1-val", Depth: 4},
+		{Key: []byte("namespace/pod-2/owner-2"), Val: "owner-2-val", Depth: 4},
+		{Key: []byte("namespaces"), Val: "namespaces-val", Depth: 2},
+	}
+	require.Equal(t, expect, slices.Collect(tree.IterateWithDepth()))
+
+	var keys [][]byte
+	for entry := range tree.IterateWithDepth() {
+		keys = append(keys, entry.Key)
+		if len(keys) == 2 {
+			break
+		}
+	}
+	require.Equal(t, tree.Keys()[:2], keys)
+}
+
+func TestIterateBudget(t *testing.T) {
+	t.Parallel()
+
+	tree := NewWithOptions(WithValueSizer(func(val string) int { return len(val) })).InsertMany(func(yield func([]byte, string) bool) {
+		// Every entry has a size of 10.
+		_ = yield([]byte("pod-1"), "val-1") &&
+			yield([]byte("pod-2"), "val-2") &&
+			yield([]byte("pod-3"), "val-3") &&
+			yield([]byte("pod-4"), "val-4")
+	})
+
+	testCases := []struct {
+		maxBytes   int
+		expectKeys []string
+	}{
+		{maxBytes: -1},
+		{maxBytes: 0},
+		{maxBytes: 9},
+		{maxBytes: 10, expectKeys: []string{"pod-1"}},
+		{maxBytes: 29, expectKeys: []string{"pod-1", "pod-2"}},
+		{maxBytes: 30, expectKeys: []string{"pod-1", "pod-2", "pod-3"}},
+		{maxBytes: 1000, expectKeys: []string{"pod-1", "pod-2", "pod-3", "pod-4"}},
+	}
+	for _, tc := range testCases {
+		var keys []string
+		for key, val := range tree.IterateBudget(tc.maxBytes) {
+			expectVal, _ := tree.Get(key)
+			require.Equal(t, expectVal, val)
+			keys = append(keys, string(key))
+		}
+		require.Equal(t, tc.expectKeys, keys, "maxBytes %d", tc.maxBytes)
+	}
+
+	// Without a value sizer, values count with the size of T.
+	var keys []string
+	for key := range NewFromMap(map[string]int64{"a": 1, "b": 2}).IterateBudget(17) {
+		keys = append(keys, string(key))
+	}
+	require.Equal(t, []string{"a"}, keys)
+}
+
+func TestSubTree(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	testCases := []struct {
+		name   string
+		prefix string
+		expect map[string]string
+	}{
+		{
+			name:   "Empty prefix",
+			prefix: "",
+			expect: tree.ToMap(),
+		},
+		{
+			name:   "Prefix ends at node boundary",
+			prefix: "namespace/pod-2/owner-",
+			expect: map[string]string{
+				"1": "owner-1-val",
+				"2": "owner-2-val",
+			},
+		},
+		{
+			name:   "Prefix ends within compressed path",
+			prefix: "namespace/pod-2/",
+			expect: map[string]string{
+				"owner-1": "owner-1-val",
+				"owner-2": "owner-2-val",
+			},
+		},
+		{
+			name:   "Prefix is a key",
+			prefix: "namespace",
+			expect: map[string]string{
+				"":               "namespace-val",
+				"/pod-1":         "pod-1-val",
+				"/pod-2/owner-1": "owner-1-val",
+				"/pod-2/owner-2": "owner-2-val",
+				"s":              "namespaces-val",
+			},
+		},
+		{
+			name:   "Prefix diverges within compressed path",
+			prefix: "namespace/pod-3",
+		},
+		{
+			name:   "Prefix longer than any key",
+			prefix: "namespace/pod-1/container",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			originalTreeDump := dumpTree(tree)
+			subTree, ok := tree.SubTree([]byte(tc.prefix))
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+			if tc.expect == nil {
+				require.False(t, ok)
+				return
+			}
+
+			require.True(t, ok)
+			validateTree(t, subTree)
+			require.Equal(t, len(tc.expect), subTree.Len())
+			require.Equal(t, tc.expect, subTree.ToMap())
+
+			// Mutating the subtree must not affect the original
+			for key := range tc.expect {
+				_, _, subTree = subTree.Delete([]byte(key))
+				validateTree(t, subTree)
+			}
+			require.True(t, subTree.IsEmpty())
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	a := NewFromMap(map[string]string{
+		"":          "a-empty",
+		"namespace": "a-namespace",
+		"foo":       "a-foo",
+	})
+	b := NewFromMap(map[string]string{
+		"namespace/pod-1": "b-pod-1",
+		"foo":             "b-foo",
+		"fo":              "b-fo",
+	})
+	originalADump, originalBDump := dumpTree(a), dumpTree(b)
+
+	var conflicts []string
+	merged := a.Merge(b, func(a, b string) string {
+		conflicts = append(conflicts, a+"+"+b)
+		return a + "+" + b
+	})
+	validateTree(t, merged)
+
+	require.Equal(t, []string{"a-foo+b-foo"}, conflicts)
+	require.Equal(t, map[string]string{
+		"":                "a-empty",
+		"namespace":       "a-namespace",
+		"namespace/pod-1": "b-pod-1",
+		"foo":             "a-foo+b-foo",
+		"fo":              "b-fo",
+	}, merged.ToMap())
+	require.Equal(t, 5, merged.Len())
+	require.Equal(t, originalADump, dumpTree(a), "original tree should be unmodified")
+	require.Equal(t, originalBDump, dumpTree(b), "original tree should be unmodified")
+
+	require.Same(t, a, a.Merge(New[string](), func(a, b string) string { return a }))
+}
+
+func TestEqual(t *testing.T) {
+	t.Parallel()
+
+	m := map[string]string{
+		"":                "empty-val",
+		"namespace":       "namespace-val",
+		"namespace/pod-1": "pod-1-val",
+		"namespaces":      "namespaces-val",
+	}
+	tree := NewFromMap(m)
+	eq := func(a, b string) bool { return a == b }
+
+	testCases := []struct {
+		name   string
+		other  *Iradix[string]
+		expect bool
+	}{
+		{
+			name:   "Same tree",
+			other:  tree,
+			expect: true,
+		},
+		{
+			name:   "Same content built differently",
+			other:  mustInsert(t, mustInsert(t, mustInsert(t, mustInsert(t, New[string](), "namespaces", "namespaces-val"), "namespace/pod-1", "pod-1-val"), "namespace", "namespace-val"), "", "empty-val"),
+			expect: true,
+		},
+		{
+			name:  "Different value",
+			other: mustInsert(t, tree, "namespace", "other-val"),
+		},
+		{
+			name:  "Different key with same size",
+			other: mustInsert(t, mustDelete(t, tree, "namespace"), "namespaced", "namespace-val"),
+		},
+		{
+			name:  "Missing key",
+			other: mustDelete(t, tree, ""),
+		},
+		{
+			name:  "Empty tree",
+			other: New[string](),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.expect, tree.Equal(tc.other, eq))
+			require.Equal(t, tc.expect, tc.other.Equal(tree, eq))
+		})
+	}
+}
+
+// mustInsert inserts val under key and fails the test if that leaves the
+// tree unchanged.
+func mustInsert[T any](t testing.TB, tree *Iradix[T], key string, val T) *Iradix[T] {
+	t.Helper()
+	_, _, newTree := tree.Insert([]byte(key), val)
+	if newTree == tree {
+		t.Fatalf("inserting %q didn't change the tree", key)
+	}
+	return newTree
+}
+
+// mustDelete deletes key and fails the test if it wasn't present.
+func mustDelete[T any](t testing.TB, tree *Iradix[T], key string) *Iradix[T] {
+	t.Helper()
+	_, existed, newTree := tree.Delete([]byte(key))
+	if !existed {
+		t.Fatalf("deleting %q: key not present", key)
+	}
+	return newTree
+}
+
+func TestCountPrefix(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	for prefix, expected := range map[string]int{
+		"":                          6,
+		"n":                         5,
+		"namespace":                 5,
+		"namespace/":                3,
+		"namespace/pod-2/":          2,
+		"namespace/pod-2/owner-1":   1,
+		"namespace/pod-3":           0,
+		"namespace/pod-1/container": 0,
+		"other":                     0,
+	} {
+		require.Equal(t, expected, tree.CountPrefix([]byte(prefix)), "prefix %q", prefix)
+	}
+
+	require.Equal(t, 0, New[string]().CountPrefix(nil))
+}
+
+func TestHasPrefix(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{
+		"namespace":               "namespace-val",
+		"namespace/pod-1":         "pod-1-val",
+		"namespace/pod-2/owner-1": "owner-1-val",
+	})
+
+	for prefix, expected := range map[string]bool{
+		"":                          true,
+		"n":                         true,
+		"namespace":                 true,
+		"namespace/pod-":            true,
+		"namespace/pod-2/owner-1":   true,
+		"namespace/pod-3":           false,
+		"namespace/pod-1/container": false,
+		"other":                     false,
+	} {
+		require.Equal(t, expected, tree.HasPrefix([]byte(prefix)), "prefix %q", prefix)
+	}
+
+	require.False(t, New[string]().HasPrefix(nil))
+	require.True(t, NewFromMap(map[string]string{"": "empty-val"}).HasPrefix(nil))
+}
+
+func TestCountPrefixAfterRandomMutations(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewPCG(3, 4))
+	randomKey := func() []byte {
+		key := make([]byte, rng.IntN(6))
+		for idx := range key {
+			key[idx] = "ab/c"[rng.IntN(4)]
+		}
+		return key
+	}
+
+	tree := New[int]()
+	expected := map[string]int{}
+	for round := range 200 {
+		// Alternate between single mutations and batches, which modify
+		// nodes owned by the txn in place.
+		if round%2 == 0 {
+			key := randomKey()
+			if rng.IntN(3) == 0 {
+				_, _, tree = tree.Delete(key)
+				delete(expected, string(key))
+			} else {
+				_, _, tree = tree.Insert(key, round)
+				expected[string(key)] = round
+			}
+		} else {
+			var deleteKeys [][]byte
+			for range 5 {
+				deleteKeys = append(deleteKeys, randomKey())
+			}
+			tree, _ = tree.DeleteMany(deleteKeys)
+			for _, key := range deleteKeys {
+				delete(expected, string(key))
+			}
+			tree = tree.InsertMany(func(yield func([]byte, int) bool) {
+				for range 5 {
+					key := randomKey()
+					expected[string(key)] = round
+					if !yield(key, round) {
+						return
+					}
+				}
+			})
+		}
+		validateTree(t, tree)
+
+		for range 10 {
+			prefix := randomKey()
+			count := 0
+			for key := range expected {
+				if bytes.HasPrefix([]byte(key), prefix) {
+					count++
+				}
+			}
+			require.Equal(t, count, tree.CountPrefix(prefix), "prefix %q in round %d", prefix, round)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	val := "val"
+	testCases := []struct {
+		name        string
+		tree        *Iradix[string]
+		expectedErr string
+	}{
+		{
+			name: "Valid tree",
+			tree: NewFromMap(map[string]string{"": "empty-val", "foo": "foo-val", "foobar": "foobar-val"}),
+		},
+		{
+			name: "Empty node",
+			tree: &Iradix[string]{len: 1, root: &node[string]{children: []*node[string]{
+				{path: []byte("foo"), children: []*node[string]{
+					{path: []byte("bar"), val: &val},
+				}},
+			}}},
+			expectedErr: `node "foo" has no value and 1 children`,
+		},
+		{
+			name: "Duplicate first byte",
+			tree: &Iradix[string]{len: 2, root: &node[string]{subtreeSize: 2, children: []*node[string]{
+				{path: []byte("foo"), val: &val, subtreeSize: 1},
+				{path: []byte("fab"), val: &val, subtreeSize: 1},
+			}}},
+			expectedErr: `children of node "" are not strictly ordered by first byte: 'f' >= 'f'`,
+		},
+		{
+			name: "Empty path",
+			tree: &Iradix[string]{len: 1, root: &node[string]{children: []*node[string]{
+				{path: []byte("foo"), val: &val, children: []*node[string]{
+					{val: &val},
+				}},
+			}}},
+			expectedErr: `node below "foo" has an empty path`,
+		},
+		{
+			name: "Wrong length",
+			tree: &Iradix[string]{len: 2, root: &node[string]{subtreeSize: 1, children: []*node[string]{
+				{path: []byte("foo"), val: &val, subtreeSize: 1},
+			}}},
+			expectedErr: "tree has length 2 but holds 1 values",
+		},
+		{
+			name: "Wrong subtree size",
+			tree: &Iradix[string]{len: 2, root: &node[string]{subtreeSize: 2, children: []*node[string]{
+				{path: []byte("foo"), val: &val, subtreeSize: 2, children: []*node[string]{
+					{path: []byte("bar"), val: &val, subtreeSize: 0},
+				}},
+			}}},
+			expectedErr: `node "foobar" has subtree size 0 but holds 1 values`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.tree.Validate()
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestNilAndEmptyKey(t *testing.T) {
+	t.Parallel()
+
+	forms := map[string][]byte{"nil": nil, "empty": {}}
+	for insertName, insertKey := range forms {
+		for lookupName, lookupKey := range forms {
+			t.Run(insertName+" then "+lookupName, func(t *testing.T) {
+				t.Parallel()
+
+				tree := NewFromMap(map[string]string{"foo": "foo-val"})
+				_, _, tree = tree.Insert(insertKey, "empty-val")
+				validateTree(t, tree)
+
+				val, found := tree.Get(lookupKey)
+				require.True(t, found)
+				require.Equal(t, "empty-val", val)
+				require.True(t, tree.Contains(lookupKey))
+
+				oldVal, existed, updated := tree.Insert(lookupKey, "other-val")
+				require.True(t, existed)
+				require.Equal(t, "empty-val", oldVal)
+				require.Equal(t, tree.Len(), updated.Len())
+
+				actual, loaded, sameTree := tree.GetOrInsert(lookupKey, "other-val")
+				require.True(t, loaded)
+				require.Equal(t, "empty-val", actual)
+				require.Same(t, tree, sameTree)
+
+				oldVal, existed, deleted := tree.Delete(lookupKey)
+				require.True(t, existed)
+				require.Equal(t, "empty-val", oldVal)
+				validateTree(t, deleted)
+				require.Equal(t, map[string]string{"foo": "foo-val"}, deleted.ToMap())
+
+				_, existed, sameTree = deleted.Delete(insertKey)
+				require.False(t, existed)
+				require.Same(t, deleted, sameTree)
+			})
+		}
+	}
+}
+
+func TestInsertDoesNotAliasKey(t *testing.T) {
+	t.Parallel()
+
+	base := map[string]string{
+		"namespace/pod-1": "pod-1-val",
+		"namespace/pod-2": "pod-2-val",
+	}
+	mutations := []struct {
+		name    string
+		newTree func() *Iradix[string]
+		insert  func(tree *Iradix[string], key []byte) *Iradix[string]
+	}{
+		{
+			name:    "Insert",
+			newTree: New[string],
+			insert: func(tree *Iradix[string], key []byte) *Iradix[string] {
+				_, _, tree = tree.Insert(key, "val")
+				return tree
+			},
+		},
+		{
+			name:    "InsertMany",
+			newTree: New[string],
+			insert: func(tree *Iradix[string], key []byte) *Iradix[string] {
+				return tree.InsertMany(func(yield func([]byte, string) bool) { yield(key, "val") })
+			},
+		},
+		{
+			name:    "InsertMany with key arena",
+			newTree: func() *Iradix[string] { return NewWithOptions(WithKeyArena[string]()) },
+			insert: func(tree *Iradix[string], key []byte) *Iradix[string] {
+				return tree.InsertMany(func(yield func([]byte, string) bool) { yield(key, "val") })
+			},
+		},
+		{
+			name:    "GetOrInsert",
+			newTree: New[string],
+			insert: func(tree *Iradix[string], key []byte) *Iradix[string] {
+				_, _, tree = tree.GetOrInsert(key, "val")
+				return tree
+			},
+		},
+		{
+			name:    "Update",
+			newTree: New[string],
+			insert: func(tree *Iradix[string], key []byte) *Iradix[string] {
+				_, tree = tree.Update(key, func(string, bool) string { return "val" })
+				return tree
+			},
+		},
+	}
+	keys := map[string]string{
+		"New leaf below existing node": "namespace/pod-3",
+		"New child of root":            "other",
+		"Split with new leaf":          "namespace/other",
+		"Split node gets value":        "namespace/p",
+		"Child of leaf":                "namespace/pod-1/owner-1",
+	}
+
+	for _, mutation := range mutations {
+		for keyName, key := range keys {
+			t.Run(mutation.name+"/"+keyName, func(t *testing.T) {
+				t.Parallel()
+
+				tree := mutation.newTree().InsertMany(func(yield func([]byte, string) bool) {
+					for key, val := range base {
+						if !yield([]byte(key), val) {
+							return
+						}
+					}
+				})
+				callerKey := []byte(key)
+				tree = mutation.insert(tree, callerKey)
+				for idx := range callerKey {
+					callerKey[idx] = 'X'
+				}
+
+				validateTree(t, tree)
+				val, found := tree.Get([]byte(key))
+				require.True(t, found)
+				require.Equal(t, "val", val)
+				require.False(t, tree.Contains(callerKey))
+				for baseKey := range base {
+					require.True(t, tree.Contains([]byte(baseKey)), "key %q", baseKey)
+				}
+			})
+		}
+	}
+}
+
+func TestRootAccessors(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{"namespace": "namespace-val"})
+	_, found := tree.GetRoot()
+	require.False(t, found)
+	_, existed, sameTree := tree.DeleteRoot()
+	require.False(t, existed)
+	require.Same(t, tree, sameTree)
+
+	withRoot := tree.SetRoot("default")
+	validateTree(t, withRoot)
+	val, found := withRoot.GetRoot()
+	require.True(t, found)
+	require.Equal(t, "default", val)
+	val, _ = withRoot.Get([]byte{})
+	require.Equal(t, "default", val, "the root holds the value of the empty key")
+	require.Equal(t, 2, withRoot.Len())
+
+	oldVal, existed, withoutRoot := withRoot.DeleteRoot()
+	require.True(t, existed)
+	require.Equal(t, "default", oldVal)
+	validateTree(t, withoutRoot)
+	require.Equal(t, tree.ToMap(), withoutRoot.ToMap())
+
+	_, found = tree.GetRoot()
+	require.False(t, found, "original tree should be unmodified")
+}
+
+func TestDeleteMany(t *testing.T) {
+	t.Parallel()
+
+	m := map[string]int{
+		"":                        0,
+		"namespace":               1,
+		"namespace/pod-1":         2,
+		"namespace/pod-2/owner-1": 3,
+		"namespace/pod-2/owner-2": 4,
+		"namespaces":              5,
+	}
+	tree := NewFromMap(m)
+
+	testCases := []struct {
+		name          string
+		keys          []string
+		expectDeleted int
+	}{
+		{name: "No keys"},
+		{name: "Missing keys are skipped", keys: []string{"namespace/", "namespace/pod-2", "other"}},
+		{name: "Duplicate keys count once", keys: []string{"namespace", "namespace"}, expectDeleted: 1},
+		{name: "Deletion causes compression", keys: []string{"namespace", "namespace/pod-1", "namespace/pod-2/owner-1"}, expectDeleted: 3},
+		{name: "Delete everything", keys: slices.Collect(maps.Keys(m)), expectDeleted: len(m)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var keys [][]byte
+			expectedTree := tree
+			for _, key := range tc.keys {
+				keys = append(keys, []byte(key))
+				_, _, expectedTree = expectedTree.Delete([]byte(key))
+			}
+
+			originalTreeDump := dumpTree(tree)
+			newTree, deleted := tree.DeleteMany(keys)
+			validateTree(t, newTree)
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+			require.Equal(t, tc.expectDeleted, deleted)
+			require.Equal(t, len(m)-deleted, newTree.Len())
+			require.Equal(t, expectedTree.ToMap(), newTree.ToMap())
+			if deleted == 0 {
+				require.Same(t, tree, newTree)
+			}
+		})
+	}
+}
+
+func TestReplacePrefix(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{
+		"":                        "empty-val",
+		"namespace":               "namespace-val",
+		"namespace/pod-1":         "pod-1-val",
+		"namespace/pod-2/owner-1": "owner-1-val",
+		"other/pod-1":             "other-pod-1-val",
+		"other/pod-3":             "other-pod-3-val",
+	})
+
+	testCases := []struct {
+		name        string
+		oldPrefix   string
+		newPrefix   string
+		expectMoved int
+		expect      map[string]string
+	}{
+		{
+			name:      "No match",
+			oldPrefix: "missing/",
+			newPrefix: "other/",
+			expect:    tree.ToMap(),
+		},
+		{
+			name:        "Same prefix",
+			oldPrefix:   "namespace/",
+			newPrefix:   "namespace/",
+			expectMoved: 2,
+			expect:      tree.ToMap(),
+		},
+		{
+			name:        "Move to new prefix",
+			oldPrefix:   "namespace/",
+			newPrefix:   "ns/",
+			expectMoved: 2,
+			expect: map[string]string{
+				"":                 "empty-val",
+				"namespace":        "namespace-val",
+				"ns/pod-1":         "pod-1-val",
+				"ns/pod-2/owner-1": "owner-1-val",
+				"other/pod-1":      "other-pod-1-val",
+				"other/pod-3":      "other-pod-3-val",
+			},
+		},
+		{
+			name:        "Collisions are overwritten",
+			oldPrefix:   "namespace/",
+			newPrefix:   "other/",
+			expectMoved: 2,
+			expect: map[string]string{
+				"":                    "empty-val",
+				"namespace":           "namespace-val",
+				"other/pod-1":         "pod-1-val",
+				"other/pod-2/owner-1": "owner-1-val",
+				"other/pod-3":         "other-pod-3-val",
+			},
+		},
+		{
+			name:        "Prefix ends within compressed path",
+			oldPrefix:   "names",
+			newPrefix:   "",
+			expectMoved: 3,
+			expect: map[string]string{
+				"":                   "empty-val",
+				"pace":               "namespace-val",
+				"pace/pod-1":         "pod-1-val",
+				"pace/pod-2/owner-1": "owner-1-val",
+				"other/pod-1":        "other-pod-1-val",
+				"other/pod-3":        "other-pod-3-val",
+			},
+		},
+		{
+			name:        "New prefix extends old prefix",
+			oldPrefix:   "other/",
+			newPrefix:   "other/other/",
+			expectMoved: 2,
+			expect: map[string]string{
+				"":                        "empty-val",
+				"namespace":               "namespace-val",
+				"namespace/pod-1":         "pod-1-val",
+				"namespace/pod-2/owner-1": "owner-1-val",
+				"other/other/pod-1":       "other-pod-1-val",
+				"other/other/pod-3":       "other-pod-3-val",
+			},
+		},
+		{
+			name:        "Empty old prefix moves everything",
+			oldPrefix:   "",
+			newPrefix:   "root/",
+			expectMoved: 6,
+			expect: map[string]string{
+				"root/":                        "empty-val",
+				"root/namespace":               "namespace-val",
+				"root/namespace/pod-1":         "pod-1-val",
+				"root/namespace/pod-2/owner-1": "owner-1-val",
+				"root/other/pod-1":             "other-pod-1-val",
+				"root/other/pod-3":             "other-pod-3-val",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			originalTreeDump := dumpTree(tree)
+			newTree, moved := tree.ReplacePrefix([]byte(tc.oldPrefix), []byte(tc.newPrefix))
+			validateTree(t, newTree)
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+			require.Equal(t, tc.expectMoved, moved)
+			require.Equal(t, tc.expect, newTree.ToMap())
+		})
+	}
+
+	t.Run("Overwrites without consulting onConflict", func(t *testing.T) {
+		t.Parallel()
+
+		sum := NewWithOptions(WithOnConflict(func(old, new int) int { return old + new }))
+		sum = mustInsert(t, sum, "old/counter", 1)
+		sum = mustInsert(t, sum, "new/counter", 10)
+		moved, n := sum.ReplacePrefix([]byte("old/"), []byte("new/"))
+		require.Equal(t, 1, n)
+		require.Equal(t, map[string]int{"new/counter": 1}, moved.ToMap())
+	})
+}
+
+func TestPopPrefix(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{
+		"":                        "empty-val",
+		"namespace":               "namespace-val",
+		"namespace/pod-1":         "pod-1-val",
+		"namespace/pod-2/owner-1": "owner-1-val",
+		"other/pod-1":             "other-pod-1-val",
+		"other/pod-3":             "other-pod-3-val",
+	})
+
+	testCases := []struct {
+		name          string
+		prefix        string
+		expectRemoved []string
+	}{
+		{
+			name:   "No match",
+			prefix: "missing/",
+		},
+		{
+			name:   "Prefix diverges within compressed path",
+			prefix: "namespaces",
+		},
+		{
+			name:          "Prefix ends at node",
+			prefix:        "namespace/",
+			expectRemoved: []string{"namespace/pod-1", "namespace/pod-2/owner-1"},
+		},
+		{
+			name:          "Prefix ends within compressed path",
+			prefix:        "names",
+			expectRemoved: []string{"namespace", "namespace/pod-1", "namespace/pod-2/owner-1"},
+		},
+		{
+			name:          "Parent gets merged with remaining child",
+			prefix:        "other/pod-3",
+			expectRemoved: []string{"other/pod-3"},
+		},
+		{
+			name:          "Whole key",
+			prefix:        "namespace/pod-2/owner-1",
+			expectRemoved: []string{"namespace/pod-2/owner-1"},
+		},
+		{
+			name:          "Empty prefix removes everything",
+			prefix:        "",
+			expectRemoved: []string{"", "namespace", "namespace/pod-1", "namespace/pod-2/owner-1", "other/pod-1", "other/pod-3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			originalTreeDump := dumpTree(tree)
+			removed, newTree := tree.PopPrefix([]byte(tc.prefix))
+			validateTree(t, newTree)
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+
+			expect := tree.ToMap()
+			var removedKeys []string
+			for key, val := range removed {
+				removedKeys = append(removedKeys, string(key))
+				require.Equal(t, expect[string(key)], val)
+				delete(expect, string(key))
+			}
+			require.Equal(t, tc.expectRemoved, removedKeys)
+			require.Equal(t, expect, newTree.ToMap())
+			if len(tc.expectRemoved) == 0 {
+				require.Same(t, tree, newTree)
+			}
+
+			// The iterator can be used again.
+			count := 0
+			for range removed {
+				count++
+			}
+			require.Equal(t, len(tc.expectRemoved), count)
+		})
+	}
+
+	t.Run("Removed keys are notified", func(t *testing.T) {
+		t.Parallel()
+
+		tree := NewFromMap(map[string]string{"namespace/pod-1": "pod-1-val", "namespace/pod-2": "pod-2-val", "other": "other-val"})
+		removedCh, _, _ := tree.GetWatch([]byte("namespace/pod-2"))
+		otherCh, _, _ := tree.GetWatch([]byte("other"))
+
+		tree.PopPrefix([]byte("namespace/"))
+		require.True(t, isClosed(removedCh))
+		require.False(t, isClosed(otherCh))
+	})
+}
+
+func TestDeleteFunc(t *testing.T) {
+	t.Parallel()
+
+	m := map[string]int{
+		"":                        0,
+		"namespace":               1,
+		"namespace/pod-1":         2,
+		"namespace/pod-2/owner-1": 3,
+		"namespace/pod-2/owner-2": 4,
+		"namespaces":              5,
+	}
+	tree := NewFromMap(m)
+
+	testCases := []struct {
+		name   string
+		pred   func(key []byte, val int) bool
+		expect []string
+	}{
+		{
+			name: "Delete nothing",
+			pred: func([]byte, int) bool { return false },
+			expect: []string{
+				"", "namespace", "namespace/pod-1", "namespace/pod-2/owner-1", "namespace/pod-2/owner-2", "namespaces",
+			},
+		},
+		{
+			name:   "Delete everything",
+			pred:   func([]byte, int) bool { return true },
+			expect: []string{},
+		},
+		{
+			name:   "Delete by value causes compression",
+			pred:   func(_ []byte, val int) bool { return val%2 == 1 },
+			expect: []string{"", "namespace/pod-1", "namespace/pod-2/owner-2"},
+		},
+		{
+			name:   "Delete by key",
+			pred:   func(key []byte, _ int) bool { return bytes.HasPrefix(key, []byte("namespace/")) },
+			expect: []string{"", "namespace", "namespaces"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			originalTreeDump := dumpTree(tree)
+			newTree, deleted := tree.DeleteFunc(tc.pred)
+			validateTree(t, newTree)
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+			require.Equal(t, len(m)-len(tc.expect), deleted)
+
+			// The result must be identical to deleting one by one
+			expectedTree := tree
+			for key, val := range m {
+				if tc.pred([]byte(key), val) {
+					_, _, expectedTree = expectedTree.Delete([]byte(key))
+				}
+			}
+			require.True(t, expectedTree.Equal(newTree, func(a, b int) bool { return a == b }))
+
+			keys := []string{}
+			for key := range newTree.Iterate() {
+				keys = append(keys, string(key))
+			}
+			require.Equal(t, tc.expect, keys)
+		})
+	}
+}
+
+func TestMap(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree("namespace/pod-2/owner-2")
+	originalTreeDump := dumpTree(tree)
+
+	mapped := tree.Map(func(key []byte, val string) string {
+		return string(key) + "=" + val
+	})
+	validateTree(t, mapped)
+	require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+	require.Equal(t, map[string]string{
+		"":                        "=empty-val",
+		"namespace":               "namespace=namespace-val",
+		"namespace/pod-1":         "namespace/pod-1=pod-1-val",
+		"namespace/pod-2/owner-1": "namespace/pod-2/owner-1=owner-1-val",
+		"namespaces":              "namespaces=namespaces-val",
+	}, mapped.ToMap())
+
+	_, _, mapped = mapped.Insert([]byte("namespace/pod-2"), "pod-2-val")
+	require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]int{
+		"":                        0,
+		"namespace":               1,
+		"namespace/pod-1":         2,
+		"namespace/pod-2/owner-1": 3,
+		"namespace/pod-2/owner-2": 4,
+		"namespaces":              5,
+	})
+	originalTreeDump := dumpTree(tree)
+
+	filtered := tree.Filter(func(key []byte, val int) bool {
+		return val%2 == 0 && len(key) > 0
+	})
+	validateTree(t, filtered)
+	require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+	require.Equal(t, map[string]int{
+		"namespace/pod-1":         2,
+		"namespace/pod-2/owner-2": 4,
+	}, filtered.ToMap())
+
+	require.True(t, tree.Filter(func([]byte, int) bool { return false }).IsEmpty())
+	require.True(t, tree.Equal(tree.Filter(func([]byte, int) bool { return true }), func(a, b int) bool { return a == b }))
+}
+
+func TestGetWatch(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{
+		"namespace":               "namespace-val",
+		"namespace/pod-1":         "pod-1-val",
+		"namespace/pod-2/owner-1": "owner-1-val",
+		"other":                   "other-val",
+	})
+
+	testCases := []struct {
+		name         string
+		watch        string
+		mutate       func(*testing.T, *Iradix[string]) *Iradix[string]
+		expectClosed bool
+	}{
+		{
+			name:  "Update of watched key",
+			watch: "namespace/pod-1",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace/pod-1", "new-val")
+			},
+			expectClosed: true,
+		},
+		{
+			name:  "Delete of watched key",
+			watch: "namespace/pod-1",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustDelete(t, tree, "namespace/pod-1")
+			},
+			expectClosed: true,
+		},
+		{
+			name:  "Insert of missing watched key",
+			watch: "namespace/pod-3",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace/pod-3", "pod-3-val")
+			},
+			expectClosed: true,
+		},
+		{
+			name:  "Insert below watched key",
+			watch: "namespace",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace/pod-3", "pod-3-val")
+			},
+			expectClosed: true,
+		},
+		{
+			name:  "Insert in other branch",
+			watch: "namespace/pod-1",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "other/foo", "foo-val")
+			},
+		},
+		{
+			name:  "Insert of identical value",
+			watch: "namespace/pod-1",
+			mutate: func(_ *testing.T, tree *Iradix[string]) *Iradix[string] {
+				_, _, tree = tree.Insert([]byte("namespace/pod-1"), "pod-1-val")
+				return tree
+			},
+		},
+		{
+			name:  "Delete of missing key",
+			watch: "namespace/pod-1",
+			mutate: func(_ *testing.T, tree *Iradix[string]) *Iradix[string] {
+				_, _, tree = tree.Delete([]byte("namespace/pod-1/container"))
+				return tree
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tree := tree.Map(func(_ []byte, val string) string { return val })
+			watchCh, val, found := tree.GetWatch([]byte(tc.watch))
+			expectedVal, expectedFound := tree.Get([]byte(tc.watch))
+			require.Equal(t, expectedVal, val)
+			require.Equal(t, expectedFound, found)
+
+			tc.mutate(t, tree)
+			require.Equal(t, tc.expectClosed, isClosed(watchCh))
+
+			// Watching a tree that has been superseded fires right away
+			staleWatchCh, _, _ := tree.GetWatch([]byte(tc.watch))
+			require.Equal(t, tc.expectClosed, isClosed(staleWatchCh))
+		})
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{
+		"namespace":               "namespace-val",
+		"namespace/pod-1":         "pod-1-val",
+		"namespace/pod-2/owner-1": "owner-1-val",
+		"namespace/pod-2/owner-2": "owner-2-val",
+		"other":                   "other-val",
+	})
+
+	testCases := []struct {
+		name         string
+		prefix       string
+		mutate       func(*testing.T, *Iradix[string]) *Iradix[string]
+		expectClosed bool
+	}{
+		{
+			name:   "Update below prefix",
+			prefix: "namespace/",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace/pod-2/owner-1", "new-val")
+			},
+			expectClosed: true,
+		},
+		{
+			name:   "Insert below prefix",
+			prefix: "namespace/",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace/pod-3", "pod-3-val")
+			},
+			expectClosed: true,
+		},
+		{
+			name:   "Delete below prefix",
+			prefix: "namespace/pod-2",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustDelete(t, tree, "namespace/pod-2/owner-2")
+			},
+			expectClosed: true,
+		},
+		{
+			name:   "Update of key equal to prefix",
+			prefix: "namespace",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace", "new-val")
+			},
+			expectClosed: true,
+		},
+		{
+			name:   "Insert splitting path of covering node",
+			prefix: "namespace/pod-2/o",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace/pod-2/other", "new-val")
+			},
+			expectClosed: true,
+		},
+		{
+			name:   "Insert of first key with prefix",
+			prefix: "namespace/svc-",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace/svc-1", "svc-1-val")
+			},
+			expectClosed: true,
+		},
+		{
+			name:   "Mutation of parent",
+			prefix: "namespace/",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace", "new-val")
+			},
+		},
+		{
+			name:   "Mutation of sibling",
+			prefix: "namespace/pod-1",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustDelete(t, tree, "namespace/pod-2/owner-2")
+			},
+		},
+		{
+			name:   "Delete of sibling merging covering node",
+			prefix: "namespace/pod-2/",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustDelete(t, tree, "namespace/pod-1")
+			},
+			expectClosed: true,
+		},
+		{
+			name:   "Mutation of other branch",
+			prefix: "namespace/",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "other/foo", "foo-val")
+			},
+		},
+		{
+			name:   "Insert of identical value",
+			prefix: "namespace/",
+			mutate: func(_ *testing.T, tree *Iradix[string]) *Iradix[string] {
+				_, _, tree = tree.Insert([]byte("namespace/pod-1"), "pod-1-val")
+				return tree
+			},
+		},
+		{
+			name:   "Removal of prefix",
+			prefix: "namespace/pod-2/",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				_, tree = tree.PopPrefix([]byte("namespace/"))
+				return tree
+			},
+			expectClosed: true,
+		},
+		{
+			name:   "Batch mutation",
+			prefix: "namespace/pod-",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				tree, _ = tree.DeleteMany([][]byte{[]byte("other"), []byte("namespace/pod-1")})
+				return tree
+			},
+			expectClosed: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tree := tree.Map(func(_ []byte, val string) string { return val })
+			watchCh := tree.WatchPrefix([]byte(tc.prefix))
+
+			tc.mutate(t, tree)
+			require.Equal(t, tc.expectClosed, isClosed(watchCh))
+			require.Equal(t, tc.expectClosed, isClosed(tree.WatchPrefix([]byte(tc.prefix))))
+		})
+	}
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	testCases := []struct {
+		prefix string
+		n      int
+		expect []string
+	}{
+		{prefix: "", n: 2, expect: []string{"", "namespace"}},
+		{prefix: "name", n: 3, expect: []string{"namespace", "namespace/pod-1", "namespace/pod-2/owner-1"}},
+		{prefix: "namespace/", n: 10, expect: []string{"namespace/pod-1", "namespace/pod-2/owner-1", "namespace/pod-2/owner-2"}},
+		{prefix: "namespace/pod-2/owner-2", n: 10, expect: []string{"namespace/pod-2/owner-2"}},
+		{prefix: "namespace/pod-3", n: 10, expect: []string{}},
+		{prefix: "namespace", n: 0, expect: []string{}},
+	}
+
+	for _, tc := range testCases {
+		var got []string
+		for _, key := range tree.Suggest([]byte(tc.prefix), tc.n) {
+			got = append(got, string(key))
+		}
+		if got == nil {
+			got = []string{}
+		}
+		require.Equal(t, tc.expect, got, "prefix %q", tc.prefix)
+	}
+
+	require.NotNil(t, tree.Suggest([]byte("other"), 1))
+}
+
+func TestValuesPrefix(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	testCases := []struct {
+		prefix string
+		expect []string
+	}{
+		{prefix: "", expect: tree.Values()},
+		{prefix: "name", expect: []string{"namespace-val", "pod-1-val", "owner-1-val", "owner-2-val", "namespaces-val"}},
+		{prefix: "namespace/", expect: []string{"pod-1-val", "owner-1-val", "owner-2-val"}},
+		{prefix: "namespace/pod-2/owner-2", expect: []string{"owner-2-val"}},
+		{prefix: "namespace/pod-3"},
+		{prefix: "other"},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expect, tree.ValuesPrefix([]byte(tc.prefix)), "prefix %q", tc.prefix)
+	}
+}
+
+func TestAt(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	for _, tree := range []*Iradix[string]{tree, mustDelete(t, tree, "")} {
+		keys, vals := tree.Keys(), tree.Values()
+		for idx := range keys {
+			key, val, ok := tree.At(idx)
+			require.True(t, ok, "index %d", idx)
+			require.Equal(t, keys[idx], key, "index %d", idx)
+			require.Equal(t, vals[idx], val, "index %d", idx)
+		}
+		for _, idx := range []int{-1, len(keys), len(keys) + 1} {
+			_, _, ok := tree.At(idx)
+			require.False(t, ok, "index %d", idx)
+		}
+	}
+
+	_, _, ok := New[string]().At(0)
+	require.False(t, ok)
+}
+
+func TestRank(t *testing.T) {
+	t.Parallel()
+
+	tree := mustInsert(t, newTestTree(), "other", "other-val")
+
+	for _, tree := range []*Iradix[string]{tree, mustDelete(t, tree, ""), New[string]()} {
+		stored := tree.Keys()
+		for _, key := range []string{
+			"", "\x00", "a", "namespace", "namespace/", "namespace/pod-", "namespace/pod-1",
+			"namespace/pod-10", "namespace/pod-2/owner-", "namespace/pod-2/owner-2",
+			"namespace/pod-3", "namespacer", "namespaces", "namespacet", "nb", "other", "others", "z",
+		} {
+			expected := 0
+			for _, storedKey := range stored {
+				if bytes.Compare(storedKey, []byte(key)) < 0 {
+					expected++
+				}
+			}
+			rank := tree.Rank([]byte(key))
+			require.Equal(t, expected, rank, "key %q", key)
+
+			if storedKey, _, ok := tree.At(rank); ok {
+				require.GreaterOrEqual(t, bytes.Compare(storedKey, []byte(key)), 0, "At(Rank(%q))", key)
+			}
+		}
+	}
+}
+
+func TestCountFunc(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]int{
+		"":                1,
+		"namespace":       2,
+		"namespace/pod-1": 3,
+		"namespace/pod-2": 4,
+		"namespaces":      5,
+	})
+
+	require.Equal(t, 5, tree.CountFunc(func([]byte, int) bool { return true }))
+	require.Equal(t, 0, tree.CountFunc(func([]byte, int) bool { return false }))
+	require.Equal(t, 2, tree.CountFunc(func(_ []byte, val int) bool { return val%2 == 0 }))
+	require.Equal(t, 2, tree.CountFunc(func(key []byte, _ int) bool { return bytes.Contains(key, []byte("pod")) }))
+	require.Equal(t, 0, New[int]().CountFunc(func([]byte, int) bool { return true }))
+}
+
+func TestCommonPrefix(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		keys   []string
+		expect string
+	}{
+		{name: "Empty tree"},
+		{name: "Single key", keys: []string{"namespace/pod-1"}, expect: "namespace/pod-1"},
+		{name: "Empty key", keys: []string{"", "namespace"}},
+		{name: "Multiple top-level children", keys: []string{"namespace", "other"}},
+		{name: "Shared prefix", keys: []string{"namespace/pod-1", "namespace/pod-2", "namespaces"}, expect: "namespace"},
+		{name: "Key is the shared prefix", keys: []string{"namespace", "namespace/pod-1"}, expect: "namespace"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tree := New[string]()
+			for _, key := range tc.keys {
+				tree = mustInsert(t, tree, key, key+"-val")
+			}
+
+			prefix := tree.CommonPrefix()
+			require.NotNil(t, prefix)
+			require.Equal(t, tc.expect, string(prefix))
+			for _, key := range tc.keys {
+				require.True(t, bytes.HasPrefix([]byte(key), prefix), "key %q", key)
+			}
+		})
+	}
+}
+
+func TestCommonAncestor(t *testing.T) {
+	t.Parallel()
+
+	tree := mustInsert(t, newTestTree(""), "other", "other-val")
+
+	testCases := []struct {
+		name   string
+		a, b   string
+		expect string
+	}{
+		{name: "Siblings", a: "namespace/pod-2/owner-1", b: "namespace/pod-2/owner-2", expect: "namespace/pod-2/owner-"},
+		{name: "Cousins", a: "namespace/pod-1", b: "namespace/pod-2/owner-1", expect: "namespace/pod-"},
+		{name: "Ancestor of other key", a: "namespace", b: "namespace/pod-1", expect: "namespace"},
+		{name: "Same key", a: "namespace/pod-1", b: "namespace/pod-1", expect: "namespace/pod-1"},
+		{name: "Divergence within compressed path", a: "namespace/pod-2/owner-1", b: "namespace/pod-2/other", expect: "namespace/pod-"},
+		{name: "Key ends within compressed path", a: "namespace/pod-2/own", b: "namespace/pod-2/owner-1", expect: "namespace/pod-"},
+		{name: "Absent keys below node", a: "namespace/pod-3", b: "namespace/pod-4", expect: "namespace/pod-"},
+		{name: "Divergence at root", a: "namespace", b: "other"},
+		{name: "Empty key", a: "", b: "namespace"},
+		{name: "Absent keys", a: "foo", b: "bar"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ancestor := tree.CommonAncestor([]byte(tc.a), []byte(tc.b))
+			require.NotNil(t, ancestor)
+			require.Equal(t, tc.expect, string(ancestor))
+			require.Equal(t, ancestor, tree.CommonAncestor([]byte(tc.b), []byte(tc.a)))
+		})
+	}
+
+	require.Equal(t, []byte{}, New[string]().CommonAncestor([]byte("foo"), []byte("foo")))
+}
+
+func TestFirstNLastN(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+	allKeys, allVals := tree.Keys(), tree.Values()
+
+	for _, n := range []int{-1, 0, 1, 2, 5, 6, 10} {
+		keys, vals := tree.FirstN(n)
+		expectLen := min(max(n, 0), len(allKeys))
+		require.Len(t, keys, expectLen, "FirstN(%d)", n)
+		if expectLen > 0 {
+			require.Equal(t, allKeys[:expectLen], keys, "FirstN(%d)", n)
+			require.Equal(t, allVals[:expectLen], vals, "FirstN(%d)", n)
+		}
+
+		keys, vals = tree.LastN(n)
+		require.Len(t, keys, expectLen, "LastN(%d)", n)
+		if expectLen > 0 {
+			require.Equal(t, allKeys[len(allKeys)-expectLen:], keys, "LastN(%d)", n)
+			require.Equal(t, allVals[len(allVals)-expectLen:], vals, "LastN(%d)", n)
+		}
+	}
+
+	keys, vals := New[string]().LastN(3)
+	require.Empty(t, keys)
+	require.Empty(t, vals)
+}
+
+func TestIteratePrefixReverse(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	for _, prefix := range []string{"", "n", "namespace", "namespace/", "namespace/pod-2/owner-1", "namespace/pod-3", "other"} {
+		var expect []string
+		for key := range tree.Iterate() {
+			if bytes.HasPrefix(key, []byte(prefix)) {
+				expect = append(expect, string(key))
+			}
+		}
+		slices.Reverse(expect)
+
+		var got []string
+		for key, val := range tree.IteratePrefixReverse([]byte(prefix)) {
+			expectVal, _ := tree.Get(key)
+			require.Equal(t, expectVal, val, "prefix %q", prefix)
+			got = append(got, string(key))
+		}
+		require.Equal(t, expect, got, "prefix %q", prefix)
+	}
+
+	var got []string
+	for key := range tree.IteratePrefixReverse([]byte("namespace/")) {
+		got = append(got, string(key))
+		if len(got) == 2 {
+			break
+		}
+	}
+	require.Equal(t, []string{"namespace/pod-2/owner-2", "namespace/pod-2/owner-1"}, got)
+}
+
+func TestIterateRelative(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	for _, prefix := range []string{"", "n", "namespace", "namespace/", "namespace/pod-2/owner-1", "namespace/pod-3", "other"} {
+		expect := map[string]string{}
+		var expectKeys []string
+		if subTree, ok := tree.SubTree([]byte(prefix)); ok {
+			expect = subTree.ToMap()
+			for _, key := range subTree.Keys() {
+				expectKeys = append(expectKeys, string(key))
+			}
+		}
+
+		got := map[string]string{}
+		var gotKeys []string
+		for key, val := range tree.IterateRelative([]byte(prefix)) {
+			got[string(key)] = val
+			gotKeys = append(gotKeys, string(key))
+		}
+		require.Equal(t, expect, got, "prefix %q", prefix)
+		require.Equal(t, expectKeys, gotKeys, "prefix %q", prefix)
+	}
+
+	var got []string
+	for key := range tree.IterateRelative([]byte("namespace/pod-")) {
+		got = append(got, string(key))
+		if len(got) == 2 {
+			break
+		}
+	}
+	require.Equal(t, []string{"1", "2/owner-1"}, got)
+}
+
+func TestLongestPrefixLen(t *testing.T) {
+	t.Parallel()
+
+	withRoot := newTestTree("namespace/pod-2/owner-2", "namespaces")
+	withoutRoot := mustDelete(t, withRoot, "")
+
+	testCases := []struct {
+		key         string
+		tree        *Iradix[string]
+		expectLen   int
+		expectVal   string
+		expectFound bool
+	}{
+		{key: "", tree: withRoot, expectLen: 0, expectVal: "empty-val", expectFound: true},
+		{key: "other", tree: withRoot, expectLen: 0, expectVal: "empty-val", expectFound: true},
+		{key: "other", tree: withoutRoot},
+		{key: "name", tree: withoutRoot},
+		{key: "namespace", tree: withoutRoot, expectLen: 9, expectVal: "namespace-val", expectFound: true},
+		{key: "namespace/pod-1/container", tree: withoutRoot, expectLen: 15, expectVal: "pod-1-val", expectFound: true},
+		{key: "namespace/pod-2/owner", tree: withoutRoot, expectLen: 9, expectVal: "namespace-val", expectFound: true},
+		{key: "namespace/pod-2/owner-1", tree: withoutRoot, expectLen: 23, expectVal: "owner-1-val", expectFound: true},
+	}
+
+	for _, tc := range testCases {
+		matchedLen, val, found := tc.tree.LongestPrefixLen([]byte(tc.key))
+		require.Equal(t, tc.expectFound, found, "key %q", tc.key)
+		require.Equal(t, tc.expectLen, matchedLen, "key %q", tc.key)
+		require.Equal(t, tc.expectVal, val, "key %q", tc.key)
+	}
+}
+
+func TestMatchingPrefixes(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree("namespace/pod-2/owner-2")
+
+	for key, expected := range map[string][]string{
+		"":                          {""},
+		"other":                     {""},
+		"namespace/pod-1/container": {"", "namespace", "namespace/pod-1"},
+		"namespace/pod-2/owner-1":   {"", "namespace", "namespace/pod-2/owner-1"},
+		"namespace/pod-2/owner":     {"", "namespace"},
+		"namespacesfoo":             {"", "namespace", "namespaces"},
+	} {
+		var got []string
+		for _, prefix := range tree.MatchingPrefixes([]byte(key)) {
+			got = append(got, string(prefix))
+		}
+		require.Equal(t, expected, got, "key %q", key)
+
+		var walked []string
+		for prefix, val := range tree.WalkPath([]byte(key)) {
+			require.Equal(t, tree.ToMap()[string(prefix)], val)
+			walked = append(walked, string(prefix))
+		}
+		require.Equal(t, expected, walked, "key %q", key)
+	}
+
+	require.Empty(t, mustDelete(t, tree, "").MatchingPrefixes([]byte("other")))
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree("")
+
+	type visit struct {
+		key    string
+		val    string
+		hasVal bool
+	}
+	var visited []visit
+	tree.Walk(func(key []byte, val string, hasVal bool) bool {
+		visited = append(visited, visit{key: string(key), val: val, hasVal: hasVal})
+		return string(key) != "namespace/pod-2/owner-"
+	})
+
+	require.Equal(t, []visit{
+		{key: ""},
+		{key: "namespace", val: "namespace-val", hasVal: true},
+		{key: "namespace/pod-"},
+		{key: "namespace/pod-1", val: "pod-1-val", hasVal: true},
+		{key: "namespace/pod-2/owner-"},
+		{key: "namespaces", val: "namespaces-val", hasVal: true},
+	}, visited)
+
+	visited = nil
+	tree.Walk(func(key []byte, val string, hasVal bool) bool {
+		visited = append(visited, visit{key: string(key), val: val, hasVal: hasVal})
+		return false
+	})
+	require.Equal(t, []visit{{key: ""}}, visited)
+}
+
+func TestWalkNodes(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree("")
+
+	type visit struct {
+		path        string
+		depth       int
+		hasVal      bool
+		numChildren int
+	}
+	var visited []visit
+	tree.WalkNodes(func(path []byte, depth int, hasVal bool, numChildren int) bool {
+		visited = append(visited, visit{path: string(path), depth: depth, hasVal: hasVal, numChildren: numChildren})
+		return string(path) != "2/owner-"
+	})
+
+	require.Equal(t, []visit{
+		{path: "", depth: 0, numChildren: 1},
+		{path: "namespace", depth: 1, hasVal: true, numChildren: 2},
+		{path: "/pod-", depth: 2, numChildren: 2},
+		{path: "1", depth: 3, hasVal: true},
+		{path: "2/owner-", depth: 3, numChildren: 2},
+		{path: "s", depth: 2, hasVal: true},
+	}, visited)
+
+	visited = nil
+	tree.WalkNodes(func(path []byte, depth int, hasVal bool, numChildren int) bool {
+		visited = append(visited, visit{path: string(path), depth: depth, hasVal: hasVal, numChildren: numChildren})
+		return false
+	})
+	require.Equal(t, []visit{{path: "", numChildren: 1}}, visited)
+
+	// Concatenating the segments along the way yields the keys Walk visits.
+	var keys, prefixes []string
+	tree.WalkNodes(func(path []byte, depth int, _ bool, _ int) bool {
+		prefixes = append(prefixes[:depth], string(path))
+		keys = append(keys, strings.Join(prefixes, ""))
+		return true
+	})
+	var nodePaths []string
+	for _, path := range tree.NodePaths() {
+		nodePaths = append(nodePaths, string(path))
+	}
+	require.Equal(t, nodePaths, keys)
+}
+
+func TestGetWithKey(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{
+		"":                "empty-val",
+		"namespace":       "namespace-val",
+		"namespace/pod-1": "pod-1-val",
+	})
+
+	for _, key := range []string{"", "namespace", "namespace/pod-1", "namespace/", "namespace/pod-1/container", "other"} {
+		storedKey, val, found := tree.GetWithKey([]byte(key))
+		expectedVal, expectedFound := tree.Get([]byte(key))
+		require.Equal(t, expectedFound, found, "key %q", key)
+		require.Equal(t, expectedVal, val, "key %q", key)
+		if found {
+			require.Equal(t, key, string(storedKey))
+		} else {
+			require.Nil(t, storedKey)
+		}
+	}
+
+	storedKey, _, _ := tree.GetWithKey([]byte("namespace/pod-1"))
+	for idx := range storedKey {
+		storedKey[idx] = 'x'
+	}
+	require.True(t, tree.Contains([]byte("namespace/pod-1")), "mutating the returned key must not affect the tree")
+}
+
+func TestGetRef(t *testing.T) {
+	t.Parallel()
+
+	type large struct {
+		Name string
+		Data [64]int
+	}
+	tree := NewFromMap(map[string]large{
+		"":          {Name: "empty"},
+		"namespace": {Name: "namespace", Data: [64]int{1, 2, 3}},
+	})
+
+	for _, key := range []string{"", "namespace", "name", "namespaces"} {
+		ref, found := tree.GetRef([]byte(key))
+		expectedVal, expectedFound := tree.Get([]byte(key))
+		require.Equal(t, expectedFound, found, "key %q", key)
+		if !found {
+			require.Nil(t, ref)
+			continue
+		}
+		require.Equal(t, expectedVal, *ref, "key %q", key)
+	}
+
+	ref, _ := tree.GetRef([]byte("namespace"))
+	_, _, newTree := tree.Insert([]byte("namespace/pod-1"), large{Name: "pod-1"})
+	newRef, _ := newTree.GetRef([]byte("namespace"))
+	require.Same(t, ref, newRef, "unchanged values should be shared between trees")
+}
+
+func TestGetFunc(t *testing.T) {
+	t.Parallel()
+
+	tree := NewFromMap(map[string]string{
+		"":                "empty-val",
+		"namespace":       "running",
+		"namespace/pod-1": "pending",
+	})
+	isRunning := func(status string) bool { return status == "running" }
+
+	testCases := []struct {
+		key      string
+		expected bool
+	}{
+		{key: "namespace", expected: true},
+		{key: "namespace/pod-1"},
+		{key: ""},
+		{key: "namespace/pod-"},
+		{key: "namespace/pod-2"},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, tree.GetFunc([]byte(tc.key), isRunning), "key %q", tc.key)
+	}
+
+	require.False(t, tree.GetFunc([]byte("missing"), func(string) bool {
+		t.Fatal("pred must not be called for absent keys")
+		return true
+	}))
+}
+
+func TestNearest(t *testing.T) {
+	t.Parallel()
+
+	tree := mustInsert(t, newTestTree(""), "apple", "apple-val")
+
+	for key, expected := range map[string]string{
+		"":                        "apple",
+		"zebra":                   "apple",
+		"apricot":                 "apple",
+		"nothing":                 "namespace",
+		"namespace":               "namespace",
+		"namespace/pod-1":         "namespace/pod-1",
+		"namespace/pod-1/foo":     "namespace/pod-1",
+		"namespace/pod-3":         "namespace/pod-1",
+		"namespace/pod-2":         "namespace/pod-2/owner-1",
+		"namespace/pod-2/owner-3": "namespace/pod-2/owner-1",
+		"namespace/pod-2/owner-2": "namespace/pod-2/owner-2",
+		"namespacex":              "namespace",
+		"namespaces/foo":          "namespaces",
+	} {
+		nearestKey, val, found := tree.Nearest([]byte(key))
+		require.True(t, found, "key %q", key)
+		require.Equal(t, expected, string(nearestKey), "key %q", key)
+		require.Equal(t, tree.ToMap()[expected], val, "key %q", key)
+	}
+
+	_, _, found := New[string]().Nearest([]byte("foo"))
+	require.False(t, found)
+
+	nearestKey, val, found := mustInsert(t, New[string](), "", "empty-val").Nearest([]byte("foo"))
+	require.True(t, found)
+	require.Empty(t, nearestKey)
+	require.Equal(t, "empty-val", val)
+}
+
+func TestGetMany(t *testing.T) {
+	t.Parallel()
+
+	tree := newTestTree()
+
+	keys := [][]byte{
+		[]byte("namespace/pod-2/owner-2"),
+		[]byte("namespace/pod-2"),
+		nil,
+		[]byte("namespace/pod-1"),
+		[]byte("namespace/pod-2/owner-1"),
+		[]byte("namespace/pod-2/owner-1"),
+		[]byte("namespace/pod-2/owner-3"),
+		[]byte("namespaces"),
+		[]byte("namespace/pod-1/container"),
+		[]byte("other"),
+		[]byte("namespace"),
+	}
+
+	results := tree.GetMany(keys)
+	require.Len(t, results, len(keys))
+	for idx, key := range keys {
+		val, found := tree.Get(key)
+		require.Equal(t, Result[string]{Val: val, Found: found}, results[idx], "key %q", key)
+	}
+
+	require.Empty(t, tree.GetMany(nil))
+}
+
+func TestSplit(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{
+		"",
+		"namespace",
+		"namespace/pod-1",
+		"namespace/pod-2/owner-1",
+		"namespace/pod-2/owner-2",
+		"namespaces",
+	}
+	m := map[string]string{}
+	for _, key := range keys {
+		m[key] = key + "-val"
+	}
+	tree := NewFromMap(m)
+
+	for _, pivot := range []string{
+		"",
+		"a",
+		"namespace",
+		"namespace/pod-",
+		"namespace/pod-2/own",
+		"namespace/pod-2/owner-2",
+		"namespace/pod-3",
+		"z",
+	} {
+		t.Run(pivot, func(t *testing.T) {
+			t.Parallel()
+
+			originalTreeDump := dumpTree(tree)
+			left, right := tree.Split([]byte(pivot))
+			validateTree(t, left)
+			validateTree(t, right)
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+
+			expectedLeft, expectedRight := map[string]string{}, map[string]string{}
+			for _, key := range keys {
+				if key < pivot {
+					expectedLeft[key] = m[key]
+				} else {
+					expectedRight[key] = m[key]
+				}
+			}
+			require.Equal(t, expectedLeft, left.ToMap())
+			require.Equal(t, expectedRight, right.ToMap())
+		})
+	}
+}
+
+func ExampleFold() {
+	tree := NewFromMap(map[string]string{
+		"namespace/pod-1": "running",
+		"namespace/pod-2": "pending",
+		"namespace/pod-3": "running",
+	})
+
+	running := Fold(tree, 0, func(count int, _ []byte, status string) int {
+		if status == "running" {
+			count++
+		}
+		return count
+	})
+	statuses := Fold(tree, "", func(acc string, key []byte, status string) string {
+		return acc + string(key) + "=" + status + ";"
+	})
+
+	fmt.Println(running)
+	fmt.Println(statuses)
+	// Output:
+	// 2
+	// namespace/pod-1=running;namespace/pod-2=pending;namespace/pod-3=running;
+}
+
+func ExampleCollect() {
+	type pod struct {
+		Name   string
+		Status string
+	}
+
+	tree := NewFromMap(map[string]string{
+		"namespace/pod-2": "pending",
+		"namespace/pod-1": "running",
+	})
+
+	pods := Collect(tree, func(key []byte, status string) pod {
+		return pod{Name: string(bytes.TrimPrefix(key, []byte("namespace/"))), Status: status}
+	})
+
+	fmt.Println(pods)
+	fmt.Println(len(Collect(New[string](), func([]byte, string) pod { return pod{} })))
+	// Output:
+	// [{pod-1 running} {pod-2 pending}]
+	// 0
+}
+
+func TestAppend(t *testing.T) {
+	t.Parallel()
+
+	// Spare capacity must not be used, it is shared with other trees.
+	owners := make([]string, 1, 10)
+	owners[0] = "owner-1"
+	_, _, tree := New[[]string]().Insert([]byte("namespace/pod-1"), owners)
+
+	appended := Append(tree, []byte("namespace/pod-1"), "owner-2", "owner-3")
+	otherAppended := Append(tree, []byte("namespace/pod-1"), "owner-4")
+	created := Append(tree, []byte("namespace/pod-2"), "owner-5")
+	validateTree(t, appended)
+	validateTree(t, created)
+
+	require.Equal(t, map[string][]string{"namespace/pod-1": {"owner-1"}}, tree.ToMap())
+	require.Equal(t, map[string][]string{"namespace/pod-1": {"owner-1", "owner-2", "owner-3"}}, appended.ToMap())
+	require.Equal(t, map[string][]string{"namespace/pod-1": {"owner-1", "owner-4"}}, otherAppended.ToMap())
+	require.Equal(t, map[string][]string{
+		"namespace/pod-1": {"owner-1"},
+		"namespace/pod-2": {"owner-5"},
+	}, created.ToMap())
+
+	// vals isn't aliased either.
+	vals := []string{"owner-6"}
+	fromVals := Append(tree, []byte("namespace/pod-3"), vals...)
+	vals[0] = "modified"
+	val, _ := fromVals.Get([]byte("namespace/pod-3"))
+	require.Equal(t, []string{"owner-6"}, val)
+
+	require.Same(t, tree, Append(tree, []byte("namespace/pod-1")))
+}
+
+// FuzzIradix applies a stream of operations decoded from the fuzz input to
+// both a tree and a map and verifies that they agree. Every operation takes
+// two bytes: The first selects the operation and the key length, the second
+// seeds the key, which is built from a small alphabet to make shared
+// prefixes, splits and merges likely.
+func FuzzIradix(f *testing.F) {
+	f.Add([]byte{0x00, 0x00, 0x13, 0x01, 0x23, 0x02, 0x11, 0x01})
+	f.Add([]byte("insert some keys and delete them again"))
+	f.Add([]byte{0x30, 0x12, 0x40, 0x12, 0x31, 0x12, 0x52, 0x12, 0x71, 0x12})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree := New[int]()
+		model := map[string]int{}
+
+		for opIdx := 0; opIdx+1 < len(data); opIdx += 2 {
+			op, keyLen, seed := data[opIdx]%3, int(data[opIdx]>>2)%8, data[opIdx+1]
+			key := make([]byte, keyLen)
+			for idx := range key {
+				key[idx] = "ab/"[(int(seed)>>idx)%3]
+			}
+			modelVal, modelExists := model[string(key)]
+
+			switch op {
+			case 0:
+				oldVal, existed, newTree := tree.Insert(key, opIdx)
+				require.Equal(t, modelExists, existed, "insert %q", key)
+				require.Equal(t, modelVal, oldVal, "insert %q", key)
+				model[string(key)] = opIdx
+				tree = newTree
+			case 1:
+				oldVal, existed, newTree := tree.Delete(key)
+				require.Equal(t, modelExists, existed, "delete %q", key)
+				require.Equal(t, modelVal, oldVal, "delete %q", key)
+				delete(model, string(key))
+				tree = newTree
+			case 2:
+				val, exists := tree.Get(key)
+				require.Equal(t, modelExists, exists, "get %q", key)
+				require.Equal(t, modelVal, val, "get %q", key)
+			}
+			validateTree(t, tree)
+		}
+
+		require.Equal(t, len(model), tree.Len())
+		require.Equal(t, model, tree.ToMap())
+	})
+}
+
+func TestParallelInsertGet(t *testing.T) {
+	t.Parallel()
+	tree := NewConcurrent(New[string]())
+
+	const writers, keysPerWriter = 8, 100
+	wg := sync.WaitGroup{}
+	for writer := range writers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for idx := range keysPerWriter {
+				key := fmt.Sprintf("writer-%d/%d", writer, idx)
+				_, existed := tree.Insert([]byte(key), key+"-val")
+				assert.False(t, existed)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for idx := range keysPerWriter {
+				key := fmt.Sprintf("writer-%d/%d", writer, idx)
+				if val, exists := tree.Get([]byte(key)); exists {
+					assert.Equal(t, key+"-val", val)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot := tree.Snapshot()
+	validateTree(t, snapshot)
+	require.Equal(t, writers*keysPerWriter, snapshot.Len())
+	for writer := range writers {
+		for idx := range keysPerWriter {
+			key := fmt.Sprintf("writer-%d/%d", writer, idx)
+			val, exists := tree.Get([]byte(key))
+			require.True(t, exists)
+			require.Equal(t, key+"-val", val)
+		}
+	}
+}
+
+func TestParallelInsertDelete(t *testing.T) {
+	t.Parallel()
+
+	const workers, keysPerWorker = 8, 100
+	initial := map[string]string{}
+	for worker := range workers {
+		for idx := range keysPerWorker {
+			initial[fmt.Sprintf("worker-%d/delete-%d", worker, idx)] = "initial"
+		}
+	}
+	tree := NewConcurrent(NewFromMap(initial))
+
+	wg := sync.WaitGroup{}
+	for worker := range workers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for idx := range keysPerWorker {
+				_, existed := tree.Delete([]byte(fmt.Sprintf("worker-%d/delete-%d", worker, idx)))
+				assert.True(t, existed)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for idx := range keysPerWorker {
+				tree.Insert([]byte(fmt.Sprintf("worker-%d/insert-%d", worker, idx)), "inserted")
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot := tree.Snapshot()
+	validateTree(t, snapshot)
+	require.Equal(t, workers*keysPerWorker, snapshot.Len())
+	for key, val := range snapshot.Iterate() {
+		require.Contains(t, string(key), "/insert-")
+		require.Equal(t, "inserted", val)
+	}
+}
+
+func TestParallelSharedTree(t *testing.T) {
+	t.Parallel()
+	tree := New[string]()
+	_, _, tree = tree.Insert([]byte("foo"), "something")
+	originalTreeDump := dumpTree(tree)
+
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+
+	go func() {
+		defer wg.Done()
+		tree.Insert([]byte("foo"), "something else")
+	}()
+
+	go func() {
+		defer wg.Done()
+		tree.Delete([]byte("foo"))
+	}()
+
+	go func() {
+		defer wg.Done()
+		tree.Get([]byte("foo"))
+	}()
+
+	wg.Wait()
+	require.Equal(t, originalTreeDump, dumpTree(tree), "shared tree should be unmodified")
+}
+
+func BenchmarkIradixWriteRead(b *testing.B) {
+	const value = "the value we store"
+	for i := 0; i < b.N; i++ {
+		tree := New[string]()
+
+		for cycle := 0; cycle < 10; cycle++ {
+			// Insert 100 elements with common prefix
+			cycle := 1
+			prefix := fmt.Sprintf("prefix%d/", cycle)
+			for j := 0; j < 100; j++ {
+				key := []byte(prefix + strconv.Itoa(j))
+				_, _, tree = tree.Insert(key, value)
+			}
+
+			// Read 100 elements 3 times with different prefixes
+			readPrefixes := []string{
+				"prefix" + strconv.Itoa(max(0, cycle-2)) + "_",
+				"prefix" + strconv.Itoa(max(0, cycle-1)) + "_",
+				"prefix" + strconv.Itoa(cycle) + "_",
+			}
+
+			for _, readPrefix := range readPrefixes {
+				for j := 0; j < 100; j++ {
+					key := []byte(readPrefix + strconv.Itoa(j))
+					tree.Get(key)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkIterate(b *testing.B) {
+	const value = "the value we store"
+	shortKeys := New[string]()
+	longKeys := New[string]()
+	for i := range 100 {
+		for j := range 1000 {
+			_, _, shortKeys = shortKeys.Insert([]byte(fmt.Sprintf("prefix%d/%d", i, j)), value)
+			_, _, longKeys = longKeys.Insert([]byte(fmt.Sprintf("%s/prefix%d/%s/%d", strings.Repeat("a", 64), i, strings.Repeat("b", 32), j)), value)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		tree *Iradix[string]
+	}{
+		{name: "Short keys", tree: shortKeys},
+		{name: "Long keys", tree: longKeys},
+	} {
+		b.Run(tc.name+"/Iterate", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for k, v := range tc.tree.Iterate() {
+					_, _ = k, v
+				}
+			}
+		})
+		b.Run(tc.name+"/Keys", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = tc.tree.Keys()
+			}
+		})
+	}
+}
+
+func BenchmarkInsertMany(b *testing.B) {
+	const value = "the value we store"
+	keys := make([][]byte, 0, 10_000)
+	for i := range 100 {
+		for j := range 100 {
+			keys = append(keys, []byte(fmt.Sprintf("prefix%d/%d", i, j)))
+		}
+	}
+
+	b.Run("Chained Insert", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tree := New[string]()
+			for _, key := range keys {
+				_, _, tree = tree.Insert(key, value)
+			}
+		}
+	})
+
+	for name, newTree := range map[string]func() *Iradix[string]{
+		"InsertMany":                New[string],
+		"InsertMany with key arena": func() *Iradix[string] { return NewWithOptions(WithKeyArena[string]()) },
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				newTree().InsertMany(func(yield func([]byte, string) bool) {
+					for _, key := range keys {
+						if !yield(key, value) {
+							return
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkBuildSorted(b *testing.B) {
+	const value = "the value we store"
+	keys := make([][]byte, 0, 10_000)
+	for i := range 100 {
+		for j := range 100 {
+			keys = append(keys, []byte(fmt.Sprintf("prefix%d/%d", i, j)))
+		}
+	}
+	slices.SortFunc(keys, bytes.Compare)
+	pairs := func(yield func([]byte, string) bool) {
+		for _, key := range keys {
+			if !yield(key, value) {
+				return
+			}
+		}
+	}
+
+	b.Run("InsertMany", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = New[string]().InsertMany(pairs)
+		}
+	})
+	b.Run("BuildSorted", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := BuildSorted(pairs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetMany(b *testing.B) {
+	const value = "the value we store"
+	tree := New[string]()
+	var keys [][]byte
+	for i := range 100 {
+		for j := range 100 {
+			key := []byte(fmt.Sprintf("prefix%d/%d", i, j))
+			_, _, tree = tree.Insert(key, value)
+			keys = append(keys, key)
+		}
+	}
+
+	b.Run("Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				tree.Get(key)
+			}
+		}
+	})
+
+	b.Run("GetMany", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree.GetMany(keys)
+		}
+	})
 }
@@ -0,0 +1,308 @@
+package iradix
+
+import (
+	"bytes"
+	"iter"
+	"slices"
+	"sort"
+)
+
+// Iterator is a pull-based cursor over the entries of a tree in key order.
+// Unlike Iterate, it can be advanced one entry at a time and repositioned
+// with SeekLowerBound.
+type Iterator[T any] struct {
+	root *node[T]
+	// stack holds the nodes that are yet to be visited, the next one last.
+	stack []iteratorFrame[T]
+	// key holds the key of the most recently visited node. The frames
+	// on the stack reference its prefixes.
+	key []byte
+}
+
+type iteratorFrame[T any] struct {
+	n *node[T]
+	// prefixLen is the length of the key of the parent of n.
+	prefixLen int
+}
+
+// Iterator returns a cursor positioned before the first entry.
+func (i *Iradix[T]) Iterator() *Iterator[T] {
+	return &Iterator[T]{
+		root:  i.root,
+		stack: []iteratorFrame[T]{{n: i.root}},
+	}
+}
+
+// Next returns the next entry. It returns false once all entries have been
+// returned. The returned key is a copy that may be retained.
+func (it *Iterator[T]) Next() (key []byte, val T, ok bool) {
+	for len(it.stack) > 0 {
+		frame := it.stack[len(it.stack)-1]
+		it.stack = it.stack[:len(it.stack)-1]
+
+		it.key = append(it.key[:frame.prefixLen], frame.n.path...)
+		it.pushChildren(frame.n.children)
+
+		if frame.n.val != nil {
+			if len(it.key) > 0 {
+				key = slices.Clone(it.key)
+			}
+			return key, *frame.n.val, true
+		}
+	}
+
+	return nil, val, false
+}
+
+// SeekLowerBound repositions the cursor so that the next call to Next
+// returns the smallest entry whose key is greater than or equal to key.
+func (it *Iterator[T]) SeekLowerBound(key []byte) {
+	it.stack = it.stack[:0]
+	it.key = it.key[:0]
+
+	n, search := it.root, key
+	for {
+		compareLen := min(len(n.path), len(search))
+		switch bytes.Compare(n.path, search[:compareLen]) {
+		case -1:
+			// All keys below n are smaller.
+			return
+		case 1:
+			// All keys below n are greater.
+			it.stack = append(it.stack, iteratorFrame[T]{n: n, prefixLen: len(it.key)})
+			return
+		}
+
+		if len(search) <= len(n.path) {
+			// key is a prefix of the path of n, so all keys below n
+			// are greater or equal.
+			it.stack = append(it.stack, iteratorFrame[T]{n: n, prefixLen: len(it.key)})
+			return
+		}
+
+		// The key of n itself is smaller, continue with the children.
+		search = search[len(n.path):]
+		it.key = append(it.key, n.path...)
+
+		greaterIdx := len(n.children)
+		for idx, child := range n.children {
+			if child.path[0] >= search[0] {
+				greaterIdx = idx
+				break
+			}
+		}
+		if greaterIdx == len(n.children) {
+			return
+		}
+
+		next := n.children[greaterIdx]
+		if next.path[0] > search[0] {
+			it.pushChildren(n.children[greaterIdx:])
+			return
+		}
+		it.pushChildren(n.children[greaterIdx+1:])
+		n = next
+	}
+}
+
+// pushChildren pushes children onto the stack such that they get visited in
+// order. They must be children of the node whose key is it.key.
+func (it *Iterator[T]) pushChildren(children []*node[T]) {
+	for _, child := range slices.Backward(children) {
+		it.stack = append(it.stack, iteratorFrame[T]{n: child, prefixLen: len(it.key)})
+	}
+}
+
+// PrefixIterator is a pull-based cursor over the entries whose key starts
+// with a given prefix.
+type PrefixIterator[T any] struct {
+	it     *Iterator[T]
+	prefix []byte
+	done   bool
+}
+
+// PrefixIterator returns a cursor positioned before the first entry whose
+// key starts with prefix.
+func (i *Iradix[T]) PrefixIterator(prefix []byte) *PrefixIterator[T] {
+	it := &PrefixIterator[T]{it: i.Iterator(), prefix: slices.Clone(prefix)}
+	it.it.SeekLowerBound(it.prefix)
+	return it
+}
+
+// Next returns the next entry. It returns false once all entries whose key
+// starts with the prefix have been returned. The returned key is the full
+// key, not just the part after the prefix.
+func (it *PrefixIterator[T]) Next() (key []byte, val T, ok bool) {
+	if it.done {
+		return nil, val, false
+	}
+
+	key, val, ok = it.it.Next()
+	if !ok || !bytes.HasPrefix(key, it.prefix) {
+		// Keys with the prefix are contiguous, so we are past them.
+		it.done = true
+		return nil, *new(T), false
+	}
+	return key, val, true
+}
+
+// SeekLowerBound repositions the cursor so that the next call to Next
+// returns the smallest entry whose key starts with the prefix and is greater
+// than or equal to key.
+func (it *PrefixIterator[T]) SeekLowerBound(key []byte) {
+	if bytes.Compare(key, it.prefix) < 0 {
+		key = it.prefix
+	}
+	it.done = false
+	it.it.SeekLowerBound(key)
+}
+
+// Page returns up to limit entries whose key starts with prefix and is
+// strictly greater than after, or all of them if after is nil. The returned
+// next is the key to pass as after to get the next page, or nil if there are
+// no more entries. A limit less than one yields nothing.
+func (i *Iradix[T]) Page(prefix, after []byte, limit int) (keys [][]byte, vals []T, next []byte) {
+	if limit < 1 {
+		return nil, nil, nil
+	}
+
+	it := i.PrefixIterator(prefix)
+	if after != nil {
+		// The smallest key greater than after is after followed by a zero byte.
+		it.SeekLowerBound(append(slices.Clone(after), 0))
+	}
+
+	for key, val, ok := it.Next(); ok; key, val, ok = it.Next() {
+		if len(keys) == limit {
+			return keys, vals, keys[len(keys)-1]
+		}
+		keys = append(keys, key)
+		vals = append(vals, val)
+	}
+	return keys, vals, nil
+}
+
+// Successor returns the entry with the smallest key strictly greater than
+// key. The returned key is a copy.
+func (i *Iradix[T]) Successor(key []byte) ([]byte, T, bool) {
+	it := i.Iterator()
+	it.seekGreater(key)
+	succKey, succVal, ok := it.Next()
+	return succKey, i.cfg.copyValue(succVal), ok
+}
+
+// IterateFrom yields all entries whose key is strictly greater than key in
+// key order, so that passing the last key of a previous iteration resumes
+// it. Every yielded key is a fresh copy.
+func (i *Iradix[T]) IterateFrom(key []byte) iter.Seq2[[]byte, T] {
+	return func(yield func([]byte, T) bool) {
+		it := i.Iterator()
+		it.seekGreater(key)
+		for key, val, ok := it.Next(); ok; key, val, ok = it.Next() {
+			if !yield(key, val) {
+				return
+			}
+		}
+	}
+}
+
+// RangeTree returns a tree holding the entries with lo <= key < hi. A nil lo
+// or hi leaves the range unbounded on that side, while an empty but non-nil
+// hi makes it empty.
+func (i *Iradix[T]) RangeTree(lo, hi []byte) *Iradix[T] {
+	t := i.empty().txn()
+	it := i.Iterator()
+	it.SeekLowerBound(lo)
+	for key, val, ok := it.Next(); ok; key, val, ok = it.Next() {
+		if hi != nil && bytes.Compare(key, hi) >= 0 {
+			break
+		}
+		t.insert(key, val)
+	}
+	return t.commit(i)
+}
+
+// seekGreater repositions the cursor so that the next call to Next returns
+// the smallest entry whose key is strictly greater than key.
+func (it *Iterator[T]) seekGreater(key []byte) {
+	// The smallest key greater than key is key followed by a zero byte.
+	it.SeekLowerBound(append(slices.Clone(key), 0))
+}
+
+// Predecessor returns the entry with the largest key strictly less than key.
+// The returned key is a copy.
+func (i *Iradix[T]) Predecessor(key []byte) ([]byte, T, bool) {
+	// candidate is the node below which the largest key less than key
+	// found so far is stored, with candidateKey being its key. If
+	// candidateSelf is set, it is the key of candidate itself.
+	var candidate *node[T]
+	var candidateKey []byte
+	candidateSelf := false
+
+	n, nodeKey, search := i.root, []byte(nil), key
+	for len(search) > 0 {
+		// The key of n is a proper prefix of key and thus smaller.
+		if n.val != nil {
+			candidate, candidateKey, candidateSelf = n, nodeKey, true
+		}
+
+		idx := sort.Search(len(n.children), func(idx int) bool {
+			return n.children[idx].path[0] >= search[0]
+		})
+		if idx > 0 {
+			// All keys below the preceding sibling are smaller, but
+			// greater than the key of n.
+			candidate, candidateKey, candidateSelf = n.children[idx-1], slices.Concat(nodeKey, n.children[idx-1].path), false
+		}
+		if idx == len(n.children) || n.children[idx].path[0] != search[0] {
+			break
+		}
+
+		child := n.children[idx]
+		commonLen := commonPrefixLen(search, child.path)
+		if commonLen < len(child.path) {
+			if commonLen < len(search) && child.path[commonLen] < search[commonLen] {
+				// key diverges within the path of child and all keys
+				// below it are smaller.
+				candidate, candidateKey, candidateSelf = child, slices.Concat(nodeKey, child.path), false
+			}
+			break
+		}
+
+		nodeKey = append(nodeKey, child.path...)
+		search = search[len(child.path):]
+		n = child
+	}
+
+	if candidate == nil {
+		return nil, *new(T), false
+	}
+	if candidateSelf {
+		return slices.Clone(candidateKey), i.cfg.copyValue(*candidate.val), true
+	}
+
+	var predKey []byte
+	var predVal T
+	reverseIterateSubtree(candidateKey, candidate, func(key []byte, val T) bool {
+		predKey, predVal = slices.Clone(key), val
+		return false
+	})
+	return predKey, i.cfg.copyValue(predVal), true
+}
+
+// Floor returns the entry with the largest key less than or equal to key.
+// The returned key is a copy.
+func (i *Iradix[T]) Floor(key []byte) ([]byte, T, bool) {
+	// The smallest key greater than key is key followed by a zero byte,
+	// so everything less than it is less than or equal to key.
+	return i.Predecessor(append(slices.Clone(key), 0))
+}
+
+// Ceil returns the entry with the smallest key greater than or equal to key.
+// The returned key is a copy.
+func (i *Iradix[T]) Ceil(key []byte) ([]byte, T, bool) {
+	it := i.Iterator()
+	it.SeekLowerBound(key)
+	ceilKey, ceilVal, ok := it.Next()
+	return ceilKey, i.cfg.copyValue(ceilVal), ok
+}
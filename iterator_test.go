@@ -0,0 +1,439 @@
+package iradix
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterator(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{
+		"",
+		"namespace",
+		"namespace/pod-1",
+		"namespace/pod-2/owner-1",
+		"namespace/pod-2/owner-2",
+		"namespaces",
+		"other",
+	}
+	m := map[string]string{}
+	for _, key := range keys {
+		m[key] = key + "-val"
+	}
+	tree := NewFromMap(m)
+
+	it := tree.Iterator()
+	var got []string
+	for key, val, ok := it.Next(); ok; key, val, ok = it.Next() {
+		require.Equal(t, string(key)+"-val", val)
+		got = append(got, string(key))
+	}
+	require.Equal(t, keys, got)
+
+	_, _, ok := New[string]().Iterator().Next()
+	require.False(t, ok)
+}
+
+func TestIteratorSeekLowerBound(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{
+		"",
+		"namespace",
+		"namespace/pod-1",
+		"namespace/pod-2/owner-1",
+		"namespace/pod-2/owner-2",
+		"namespaces",
+		"other",
+	}
+	m := map[string]string{}
+	for _, key := range keys {
+		m[key] = key + "-val"
+	}
+	tree := NewFromMap(m)
+
+	for _, seek := range []string{
+		"",
+		"a",
+		"namespace",
+		"namespace/",
+		"namespace/pod-",
+		"namespace/pod-1",
+		"namespace/pod-10",
+		"namespace/pod-2/owner-",
+		"namespace/pod-2/owner-2",
+		"namespace/pod-3",
+		"namespacer",
+		"namespaces",
+		"namespacet",
+		"nb",
+		"other",
+		"others",
+		"z",
+	} {
+		t.Run(seek, func(t *testing.T) {
+			t.Parallel()
+
+			expected := slices.DeleteFunc(slices.Clone(keys), func(key string) bool {
+				return bytes.Compare([]byte(key), []byte(seek)) < 0
+			})
+
+			// Advance first to verify repositioning
+			it := tree.Iterator()
+			it.Next()
+			it.Next()
+			it.SeekLowerBound([]byte(seek))
+
+			got := []string{}
+			for key, _, ok := it.Next(); ok; key, _, ok = it.Next() {
+				got = append(got, string(key))
+			}
+			require.Equal(t, expected, got)
+		})
+	}
+}
+
+func TestPrefixIterator(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{
+		"",
+		"namespace",
+		"namespace/pod-1",
+		"namespace/pod-2/owner-1",
+		"namespace/pod-2/owner-2",
+		"namespaces",
+		"other",
+	}
+	m := map[string]string{}
+	for _, key := range keys {
+		m[key] = key + "-val"
+	}
+	tree := NewFromMap(m)
+
+	testCases := []struct {
+		name   string
+		prefix string
+		seek   string
+		expect []string
+	}{
+		{
+			name:   "Empty prefix",
+			expect: keys,
+		},
+		{
+			name:   "Prefix is a key",
+			prefix: "namespace",
+			expect: []string{"namespace", "namespace/pod-1", "namespace/pod-2/owner-1", "namespace/pod-2/owner-2", "namespaces"},
+		},
+		{
+			name:   "Prefix ends within compressed path",
+			prefix: "namespace/pod-2/",
+			expect: []string{"namespace/pod-2/owner-1", "namespace/pod-2/owner-2"},
+		},
+		{
+			name:   "Prefix without matches",
+			prefix: "namespace/pod-3",
+			expect: []string{},
+		},
+		{
+			name:   "Seek within prefix",
+			prefix: "namespace/",
+			seek:   "namespace/pod-10",
+			expect: []string{"namespace/pod-2/owner-1", "namespace/pod-2/owner-2"},
+		},
+		{
+			name:   "Seek before prefix",
+			prefix: "namespace/",
+			seek:   "a",
+			expect: []string{"namespace/pod-1", "namespace/pod-2/owner-1", "namespace/pod-2/owner-2"},
+		},
+		{
+			name:   "Seek after prefix",
+			prefix: "namespace/",
+			seek:   "namespaces",
+			expect: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			it := tree.PrefixIterator([]byte(tc.prefix))
+			if tc.seek != "" {
+				// Exhaust first to verify repositioning resets the cursor
+				for _, _, ok := it.Next(); ok; _, _, ok = it.Next() {
+				}
+				it.SeekLowerBound([]byte(tc.seek))
+			}
+
+			got := []string{}
+			for key, val, ok := it.Next(); ok; key, val, ok = it.Next() {
+				require.Equal(t, string(key)+"-val", val)
+				got = append(got, string(key))
+			}
+			require.Equal(t, tc.expect, got)
+
+			_, _, ok := it.Next()
+			require.False(t, ok)
+		})
+	}
+}
+
+func TestPage(t *testing.T) {
+	t.Parallel()
+
+	m := map[string]int{"": -1, "other": -2}
+	for idx := range 25 {
+		m[fmt.Sprintf("namespace/pod-%02d", idx)] = idx
+	}
+	tree := NewFromMap(m)
+
+	for _, limit := range []int{1, 7, 25, 30} {
+		t.Run(strconv.Itoa(limit), func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				after   []byte
+				gotVals []int
+				pages   int
+			)
+			for {
+				keys, vals, next := tree.Page([]byte("namespace/"), after, limit)
+				require.LessOrEqual(t, len(keys), limit)
+				require.Len(t, vals, len(keys))
+				for idx, key := range keys {
+					require.Equal(t, m[string(key)], vals[idx])
+				}
+				gotVals = append(gotVals, vals...)
+				pages++
+				if next == nil {
+					break
+				}
+				require.Equal(t, keys[len(keys)-1], next)
+				after = next
+			}
+
+			expectedVals := make([]int, 25)
+			for idx := range expectedVals {
+				expectedVals[idx] = idx
+			}
+			require.Equal(t, expectedVals, gotVals)
+			require.Equal(t, (25+limit-1)/limit, pages)
+		})
+	}
+
+	keys, vals, next := tree.Page([]byte("namespace/"), []byte("namespace/pod-24"), 10)
+	require.Empty(t, keys)
+	require.Empty(t, vals)
+	require.Nil(t, next)
+
+	keys, _, _ = tree.Page(nil, nil, 0)
+	require.Empty(t, keys)
+
+	keys, _, next = tree.Page(nil, []byte("namespace/pod-23"), 10)
+	require.Equal(t, [][]byte{[]byte("namespace/pod-24"), []byte("other")}, keys)
+	require.Nil(t, next)
+}
+
+func TestPredecessorSuccessor(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{
+		"",
+		"namespace",
+		"namespace/pod-1",
+		"namespace/pod-2/owner-1",
+		"namespace/pod-2/owner-2",
+		"namespaces",
+		"other",
+	}
+	m := map[string]string{}
+	for _, key := range keys {
+		m[key] = key + "-val"
+	}
+	withRoot := NewFromMap(m)
+	withoutRoot := mustDelete(t, withRoot, "")
+
+	probes := []string{
+		"", "\x00", "a", "namespace", "namespace\x00", "namespace/", "namespace/pod-", "namespace/pod-1",
+		"namespace/pod-10", "namespace/pod-2", "namespace/pod-2/owner-", "namespace/pod-2/owner-2",
+		"namespace/pod-3", "namespacer", "namespaces", "namespacet", "nb", "other", "others", "z",
+	}
+
+	for name, tree := range map[string]*Iradix[string]{"With root": withRoot, "Without root": withoutRoot} {
+		stored := tree.Keys()
+		for _, probe := range probes {
+			t.Run(name+"/"+probe, func(t *testing.T) {
+				t.Parallel()
+
+				var expectPred, expectSucc, expectFloor, expectCeil []byte
+				predOK, succOK, floorOK, ceilOK := false, false, false, false
+				for _, key := range stored {
+					cmp := bytes.Compare(key, []byte(probe))
+					if cmp < 0 {
+						expectPred, predOK = key, true
+					}
+					if cmp <= 0 {
+						expectFloor, floorOK = key, true
+					}
+					if !succOK && cmp > 0 {
+						expectSucc, succOK = key, true
+					}
+					if !ceilOK && cmp >= 0 {
+						expectCeil, ceilOK = key, true
+					}
+				}
+
+				key, val, ok := tree.Predecessor([]byte(probe))
+				require.Equal(t, predOK, ok, "predecessor found")
+				require.Equal(t, expectPred, key)
+				if ok {
+					require.Equal(t, string(key)+"-val", val)
+				}
+
+				key, val, ok = tree.Successor([]byte(probe))
+				require.Equal(t, succOK, ok, "successor found")
+				require.Equal(t, expectSucc, key)
+				if ok {
+					require.Equal(t, string(key)+"-val", val)
+				}
+
+				key, val, ok = tree.Floor([]byte(probe))
+				require.Equal(t, floorOK, ok, "floor found")
+				require.Equal(t, expectFloor, key)
+				if ok {
+					require.Equal(t, string(key)+"-val", val)
+				}
+
+				key, val, ok = tree.Ceil([]byte(probe))
+				require.Equal(t, ceilOK, ok, "ceil found")
+				require.Equal(t, expectCeil, key)
+				if ok {
+					require.Equal(t, string(key)+"-val", val)
+				}
+			})
+		}
+	}
+
+	_, _, ok := New[string]().Predecessor([]byte("foo"))
+	require.False(t, ok)
+	_, _, ok = New[string]().Successor(nil)
+	require.False(t, ok)
+	_, _, ok = New[string]().Floor([]byte("foo"))
+	require.False(t, ok)
+	_, _, ok = New[string]().Ceil(nil)
+	require.False(t, ok)
+}
+
+func TestIterateFrom(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{
+		"",
+		"namespace",
+		"namespace/pod-1",
+		"namespace/pod-2/owner-1",
+		"namespace/pod-2/owner-2",
+		"namespaces",
+		"other",
+	}
+	m := map[string]string{}
+	for _, key := range keys {
+		m[key] = key + "-val"
+	}
+	tree := NewFromMap(m)
+
+	for _, from := range []string{"", "a", "namespace", "namespace/", "namespace/pod-2/owner-1", "namespaces", "other", "z"} {
+		expected := slices.DeleteFunc(slices.Clone(keys), func(key string) bool {
+			return bytes.Compare([]byte(key), []byte(from)) <= 0
+		})
+
+		got := []string{}
+		for key, val := range tree.IterateFrom([]byte(from)) {
+			require.Equal(t, string(key)+"-val", val)
+			got = append(got, string(key))
+		}
+		require.Equal(t, expected, got, "from %q", from)
+	}
+
+	// Resuming from the last key of every chunk visits all entries once.
+	var got []string
+	var last []byte
+	for {
+		chunk := 0
+		for key := range tree.IterateFrom(last) {
+			got = append(got, string(key))
+			last = key
+			chunk++
+			if chunk == 2 {
+				break
+			}
+		}
+		if chunk == 0 {
+			break
+		}
+	}
+	require.Equal(t, keys[1:], got, "the empty key is never greater than the cursor")
+}
+
+func TestRangeTree(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{
+		"",
+		"namespace",
+		"namespace/pod-1",
+		"namespace/pod-2/owner-1",
+		"namespace/pod-2/owner-2",
+		"namespaces",
+		"other",
+	}
+	m := map[string]string{}
+	for _, key := range keys {
+		m[key] = key + "-val"
+	}
+	tree := NewFromMap(m)
+
+	testCases := []struct {
+		name   string
+		lo, hi []byte
+		expect []string
+	}{
+		{name: "Unbounded", expect: keys},
+		{name: "Empty hi", hi: []byte{}},
+		{name: "Lower bound only", lo: []byte("namespace/"), expect: keys[2:]},
+		{name: "Upper bound only", hi: []byte("namespace/pod-2/owner-2"), expect: keys[:4]},
+		{name: "Both bounds", lo: []byte("namespace"), hi: []byte("namespaces"), expect: keys[1:5]},
+		{name: "Bounds between keys", lo: []byte("namespace/pod-10"), hi: []byte("namespacet"), expect: keys[3:6]},
+		{name: "Inverted bounds", lo: []byte("other"), hi: []byte("namespace")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			originalTreeDump := dumpTree(tree)
+			ranged := tree.RangeTree(tc.lo, tc.hi)
+			validateTree(t, ranged)
+			require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+
+			got := []string{}
+			for key, val := range ranged.Iterate() {
+				require.Equal(t, string(key)+"-val", val)
+				got = append(got, string(key))
+			}
+			if tc.expect == nil {
+				tc.expect = []string{}
+			}
+			require.Equal(t, tc.expect, got)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package iradix
+
+import "sync/atomic"
+
+// Stats holds the cumulative node operations of the mutations of a tree
+// configured with WithStats and all trees derived from it.
+type Stats struct {
+	// NodesCopied is the number of existing nodes that were copied
+	// before being modified.
+	NodesCopied uint64
+	// NodesAllocated is the number of nodes that were created from
+	// scratch, i.e. new leaves and split nodes.
+	NodesAllocated uint64
+	// Splits is the number of compressed paths that were split to insert
+	// a key diverging from them or ending within them.
+	Splits uint64
+	// Merges is the number of valueless nodes that were merged with their
+	// only child after a delete.
+	Merges uint64
+}
+
+// treeStats is the shared, concurrency-safe counterpart of Stats. All of its
+// methods may be called on a nil treeStats, which counts nothing.
+type treeStats struct {
+	nodesCopied    atomic.Uint64
+	nodesAllocated atomic.Uint64
+	splits         atomic.Uint64
+	merges         atomic.Uint64
+}
+
+func (s *treeStats) nodeCopied() {
+	if s != nil {
+		s.nodesCopied.Add(1)
+	}
+}
+
+func (s *treeStats) nodeAllocated() {
+	if s != nil {
+		s.nodesAllocated.Add(1)
+	}
+}
+
+func (s *treeStats) split() {
+	if s != nil {
+		s.splits.Add(1)
+	}
+}
+
+func (s *treeStats) merge() {
+	if s != nil {
+		s.merges.Add(1)
+	}
+}
+
+// Stats returns the node operations counted so far by all trees sharing the
+// configuration of i. Only mutations made through txns like Insert, Delete
+// or InsertMany are counted, operations building a new tree like Map or
+// SubTree aren't. Trees not configured with WithStats return zero Stats.
+func (i *Iradix[T]) Stats() Stats {
+	s := i.cfg.treeStats()
+	if s == nil {
+		return Stats{}
+	}
+	return Stats{
+		NodesCopied:    s.nodesCopied.Load(),
+		NodesAllocated: s.nodesAllocated.Load(),
+		Splits:         s.splits.Load(),
+		Merges:         s.merges.Load(),
+	}
+}
@@ -0,0 +1,58 @@
+package iradix
+
+import "iter"
+
+// StringRadix is an Iradix keyed by strings. Keys are converted to and from
+// byte slices at the API boundary only.
+type StringRadix[T any] struct {
+	tree *Iradix[T]
+}
+
+// NewString returns an empty tree keyed by strings.
+func NewString[T any]() *StringRadix[T] {
+	return &StringRadix[T]{tree: New[T]()}
+}
+
+// Get returns the value stored under key and whether it was present.
+func (s *StringRadix[T]) Get(key string) (T, bool) {
+	return s.tree.Get([]byte(key))
+}
+
+// Insert stores val under key and returns the previously stored value, if
+// any, along with the resulting tree.
+func (s *StringRadix[T]) Insert(key string, val T) (oldVal T, existed bool, newTree *StringRadix[T]) {
+	oldVal, existed, tree := s.tree.Insert([]byte(key), val)
+	return oldVal, existed, s.wrap(tree)
+}
+
+// Delete removes key and returns its value, if any, along with the resulting
+// tree.
+func (s *StringRadix[T]) Delete(key string) (oldVal T, existed bool, newTree *StringRadix[T]) {
+	oldVal, existed, tree := s.tree.Delete([]byte(key))
+	return oldVal, existed, s.wrap(tree)
+}
+
+// Iterate yields all entries in lexicographical key order.
+func (s *StringRadix[T]) Iterate() iter.Seq2[string, T] {
+	return func(yield func(string, T) bool) {
+		for key, val := range s.tree.Iterate() {
+			if !yield(string(key), val) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns the number of entries.
+func (s *StringRadix[T]) Len() int { return s.tree.Len() }
+
+// Tree returns the underlying byte-keyed tree.
+func (s *StringRadix[T]) Tree() *Iradix[T] { return s.tree }
+
+// wrap returns s if tree is unchanged so identity comparisons keep working.
+func (s *StringRadix[T]) wrap(tree *Iradix[T]) *StringRadix[T] {
+	if tree == s.tree {
+		return s
+	}
+	return &StringRadix[T]{tree: tree}
+}
@@ -0,0 +1,48 @@
+package iradix
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringRadix(t *testing.T) {
+	t.Parallel()
+
+	tree := NewString[int]()
+	for idx, key := range []string{"namespace", "", "namespace/pod-1", "namespaces"} {
+		oldVal, existed, newTree := tree.Insert(key, idx)
+		require.False(t, existed)
+		require.Zero(t, oldVal)
+		validateTree(t, newTree.Tree())
+		tree = newTree
+	}
+	require.Equal(t, 4, tree.Len())
+
+	_, existed, sameTree := tree.Insert("namespace", 0)
+	require.True(t, existed)
+	require.Same(t, tree, sameTree)
+
+	val, exists := tree.Get("namespace/pod-1")
+	require.True(t, exists)
+	require.Equal(t, 2, val)
+
+	var keys []string
+	for key := range tree.Iterate() {
+		keys = append(keys, key)
+	}
+	require.Equal(t, []string{"", "namespace", "namespace/pod-1", "namespaces"}, keys)
+
+	oldVal, existed, newTree := tree.Delete("namespace")
+	require.True(t, existed)
+	require.Equal(t, 0, oldVal)
+	validateTree(t, newTree.Tree())
+	require.Equal(t, 3, newTree.Len())
+
+	_, existed, sameTree = newTree.Delete("namespace")
+	require.False(t, existed)
+	require.Same(t, newTree, sameTree)
+
+	_, exists = tree.Get("namespace")
+	require.True(t, exists, "original tree should be unmodified")
+}
@@ -0,0 +1,340 @@
+package iradix
+
+import (
+	"bytes"
+	"slices"
+)
+
+// txn applies a sequence of mutations to a tree. Nodes that were created by
+// the txn are tracked in written and modified in place by subsequent
+// mutations, so each node is copied at most once. A txn with a nil written
+// set copies on every mutation, which is what single-key operations use as
+// they touch every node only once anyway.
+type txn[T any] struct {
+	root    *node[T]
+	len     int
+	cfg     *config[T]
+	written map[*node[T]]struct{}
+	// fold caches cfg.foldsCase() for the descents to keys.
+	fold bool
+	// arena is the unused remainder of the current key arena chunk, if
+	// the txn uses one.
+	arena []byte
+}
+
+// arenaChunkSize is the size of the chunks node paths are allocated from
+// when using a key arena.
+const arenaChunkSize = 4096
+
+func (i *Iradix[T]) txn() *txn[T] {
+	return &txn[T]{
+		root:    i.root,
+		len:     i.len,
+		cfg:     i.cfg,
+		fold:    i.cfg.foldsCase(),
+		written: map[*node[T]]struct{}{},
+	}
+}
+
+// singleTxn returns a txn for a single mutation.
+func (i *Iradix[T]) singleTxn() *txn[T] {
+	return &txn[T]{root: i.root, len: i.len, cfg: i.cfg, fold: i.cfg.foldsCase()}
+}
+
+func (t *txn[T]) commit(i *Iradix[T]) *Iradix[T] {
+	if t.root == i.root {
+		return i
+	}
+	return i.derive(t.root, t.len)
+}
+
+// writeNode returns a copy of n that may be modified, or n itself if it
+// already belongs to the txn.
+func (t *txn[T]) writeNode(n *node[T]) *node[T] {
+	if _, written := t.written[n]; written {
+		return n
+	}
+	n.notify()
+	t.cfg.treeStats().nodeCopied()
+	return t.track(copyNode(n))
+}
+
+// clonePath returns a copy of path that can be stored in a node.
+func (t *txn[T]) clonePath(path []byte) []byte {
+	return t.concatPaths(path, nil)
+}
+
+// concatPaths returns a new path consisting of a followed by b. Batch txns
+// of trees that use a key arena carve it out of a shared chunk rather than
+// allocating it individually.
+func (t *txn[T]) concatPaths(a, b []byte) []byte {
+	size := len(a) + len(b)
+	if t.written == nil || !t.cfg.useKeyArena() || size > arenaChunkSize/4 {
+		return append(slices.Clone(a), b...)
+	}
+
+	if len(t.arena) < size {
+		t.arena = make([]byte, arenaChunkSize)
+	}
+	path := t.arena[:size:size]
+	t.arena = t.arena[size:]
+	copy(path, a)
+	copy(path[len(a):], b)
+	return path
+}
+
+// track marks a freshly allocated node as owned by the txn.
+func (t *txn[T]) track(n *node[T]) *node[T] {
+	if t.written != nil {
+		t.written[n] = struct{}{}
+	}
+	return n
+}
+
+// insert stores val under key unless an equal value is already stored there.
+// If key is present, the conflict is resolved according to the config.
+func (t *txn[T]) insert(key []byte, val T) (oldVal T, existed bool) {
+	t.upsert(key, func(old T, exists bool) (T, bool) {
+		oldVal, existed = old, exists
+		if !exists {
+			return val, true
+		}
+		newVal := t.cfg.resolveConflict(old, val)
+		return newVal, !t.cfg.equal(old, newVal)
+	})
+	return oldVal, existed
+}
+
+// overwrite stores val under key unless an equal value is already stored
+// there. Unlike insert, it ignores the conflict resolution of the config.
+func (t *txn[T]) overwrite(key []byte, val T) {
+	t.upsert(key, func(old T, exists bool) (T, bool) {
+		return val, !exists || !t.cfg.equal(old, val)
+	})
+}
+
+// upsert descends to key and calls f with the value currently stored there.
+// If f returns false, nothing is written. Otherwise the returned value is
+// stored as the config asks for and the nodes on the path are copied on the
+// way back up, so only a single descent is needed. It panics if key exceeds
+// the maximum key length of the config.
+func (t *txn[T]) upsert(key []byte, f func(old T, exists bool) (T, bool)) {
+	if err := t.cfg.checkKeyLen(key); err != nil {
+		panic(err)
+	}
+	t.root = t.upsertFrom(t.root, key, f)
+}
+
+func (t *txn[T]) upsertFrom(n *node[T], key []byte, f func(old T, exists bool) (T, bool)) *node[T] {
+	if len(key) == 0 {
+		var oldVal T
+		if n.val != nil {
+			oldVal = *n.val
+		}
+		newVal, write := f(oldVal, n.val != nil)
+		if !write {
+			return n
+		}
+		newNode := t.writeNode(n)
+		if n.val == nil {
+			t.len++
+			newNode.subtreeSize++
+		}
+		newNode.val = t.cfg.storeValue(newVal)
+		return newNode
+	}
+
+	childIdx := findChild(n.children, key[0])
+	if childIdx == -1 && t.fold {
+		childIdx = findToggledChild(n.children, key[0])
+	}
+	if childIdx == -1 {
+		newVal, write := f(*new(T), false)
+		if !write {
+			return n
+		}
+		t.len++
+		newNode := t.writeNode(n)
+		newNode.subtreeSize++
+		t.cfg.treeStats().nodeAllocated()
+		insertChild(newNode, t.track(&node[T]{
+			path:        t.clonePath(key),
+			val:         t.cfg.storeValue(newVal),
+			subtreeSize: 1,
+		}))
+		return newNode
+	}
+
+	child := n.children[childIdx]
+	commonLen := commonPrefixLen(key, child.path)
+	if commonLen < len(child.path) && t.fold {
+		commonLen = commonPrefixLenFold(key, child.path)
+	}
+
+	if commonLen == len(child.path) {
+		// A child owned by the txn is modified in place, so the change
+		// in length tells whether the size of n changed.
+		lenBefore := t.len
+		newChild := t.upsertFrom(child, key[commonLen:], f)
+		if newChild == child && t.len == lenBefore {
+			return n
+		}
+		newNode := t.writeNode(n)
+		newNode.children[childIdx] = newChild
+		newNode.subtreeSize += t.len - lenBefore
+		return newNode
+	}
+
+	newVal, write := f(*new(T), false)
+	if !write {
+		return n
+	}
+	t.len++
+
+	stats := t.cfg.treeStats()
+	stats.split()
+	stats.nodeAllocated()
+	splitNode := t.track(&node[T]{
+		path:        child.path[:commonLen],
+		subtreeSize: child.subtreeSize + 1,
+	})
+	childCopy := t.writeNode(child)
+	childCopy.path = child.path[commonLen:]
+	insertChild(splitNode, childCopy)
+
+	if commonLen == len(key) {
+		splitNode.val = t.cfg.storeValue(newVal)
+	} else {
+		stats.nodeAllocated()
+		insertChild(splitNode, t.track(&node[T]{
+			path:        t.clonePath(key[commonLen:]),
+			val:         t.cfg.storeValue(newVal),
+			subtreeSize: 1,
+		}))
+	}
+
+	newNode := t.writeNode(n)
+	newNode.children[childIdx] = splitNode
+	newNode.subtreeSize++
+	return newNode
+}
+
+// delete removes key, compressing the nodes on its path afterwards.
+func (t *txn[T]) delete(key []byte) (oldVal T, existed bool) {
+	t.root, oldVal, existed = t.deleteFrom(t.root, key, true)
+	if existed {
+		t.len--
+	}
+	return oldVal, existed
+}
+
+func (t *txn[T]) deleteFrom(n *node[T], key []byte, isRoot bool) (newNode *node[T], oldVal T, existed bool) {
+	if len(key) == 0 {
+		if n.val == nil {
+			return n, oldVal, false
+		}
+		oldVal = *n.val
+		newNode = t.writeNode(n)
+		newNode.val = nil
+		newNode.subtreeSize--
+		return t.compress(newNode, isRoot), oldVal, true
+	}
+
+	childIdx := findChild(n.children, key[0])
+	if childIdx == -1 && t.fold {
+		childIdx = findToggledChild(n.children, key[0])
+	}
+	if childIdx == -1 {
+		return n, oldVal, false
+	}
+	child := n.children[childIdx]
+	if !bytes.HasPrefix(key, child.path) && (!t.fold || !hasPrefixFold(key, child.path)) {
+		return n, oldVal, false
+	}
+
+	newChild, oldVal, existed := t.deleteFrom(child, key[len(child.path):], false)
+	if !existed {
+		return n, oldVal, false
+	}
+
+	newNode = t.writeNode(n)
+	newNode.subtreeSize--
+	if newChild == nil {
+		newNode.children = slices.Delete(newNode.children, childIdx, childIdx+1)
+	} else {
+		newNode.children[childIdx] = newChild
+	}
+	return t.compress(newNode, isRoot), oldVal, true
+}
+
+// deletePrefix detaches the node covering all keys that start with prefix,
+// if there is one, and returns it along with the part of its path beyond
+// prefix. The detached nodes are notified but otherwise left untouched.
+func (t *txn[T]) deletePrefix(prefix []byte) (removed *node[T], suffix []byte) {
+	if len(prefix) == 0 {
+		if t.root.subtreeSize == 0 {
+			return nil, nil
+		}
+		removed, t.root = t.root, t.track(&node[T]{})
+	} else {
+		t.root, removed, suffix = t.deletePrefixFrom(t.root, prefix, true)
+		if removed == nil {
+			return nil, nil
+		}
+	}
+	removed.notifySubtree()
+	t.len -= removed.subtreeSize
+	return removed, suffix
+}
+
+func (t *txn[T]) deletePrefixFrom(n *node[T], prefix []byte, isRoot bool) (newNode, removed *node[T], suffix []byte) {
+	childIdx := findChild(n.children, prefix[0])
+	if childIdx == -1 {
+		return n, nil, nil
+	}
+	child := n.children[childIdx]
+	commonLen := commonPrefixLen(prefix, child.path)
+
+	var newChild *node[T]
+	switch {
+	case commonLen == len(prefix):
+		removed, suffix = child, child.path[commonLen:]
+	case commonLen == len(child.path):
+		newChild, removed, suffix = t.deletePrefixFrom(child, prefix[commonLen:], false)
+		if removed == nil {
+			return n, nil, nil
+		}
+	default:
+		return n, nil, nil
+	}
+
+	newNode = t.writeNode(n)
+	newNode.subtreeSize -= removed.subtreeSize
+	if newChild == nil {
+		newNode.children = slices.Delete(newNode.children, childIdx, childIdx+1)
+	} else {
+		newNode.children[childIdx] = newChild
+	}
+	return t.compress(newNode, isRoot), removed, suffix
+}
+
+// compress removes n if it is empty or merges it with its only child if it
+// has no value. The root is never compressed.
+func (t *txn[T]) compress(n *node[T], isRoot bool) *node[T] {
+	if isRoot || n.val != nil {
+		return n
+	}
+
+	switch len(n.children) {
+	case 0:
+		return nil
+	case 1:
+		onlyChild := n.children[0]
+		t.cfg.treeStats().merge()
+		merged := t.writeNode(onlyChild)
+		merged.path = t.concatPaths(n.path, onlyChild.path)
+		return merged
+	default:
+		return n
+	}
+}
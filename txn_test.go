@@ -0,0 +1,163 @@
+package iradix
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnMixedMutations(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	tree := New[int]()
+	expected := map[string]int{}
+	for range 20 {
+		originalTreeDump := dumpTree(tree)
+
+		txn := tree.txn()
+		for range 50 {
+			key := strconv.Itoa(rng.IntN(200))
+			if rng.IntN(3) == 0 {
+				_, existed := txn.delete([]byte(key))
+				_, expectExisted := expected[key]
+				require.Equal(t, expectExisted, existed)
+				delete(expected, key)
+				continue
+			}
+			val := rng.Int()
+			txn.insert([]byte(key), val)
+			expected[key] = val
+		}
+		newTree := txn.commit(tree)
+
+		require.Equal(t, originalTreeDump, dumpTree(tree), "original tree should be unmodified")
+		validateTree(t, newTree)
+		require.Equal(t, len(expected), newTree.Len())
+		for k, v := range newTree.Iterate() {
+			require.Equal(t, expected[string(k)], v)
+		}
+		tree = newTree
+	}
+}
+
+type nodeSnapshot[T any] struct {
+	path        []byte
+	val         *T
+	children    []*node[T]
+	subtreeSize int
+}
+
+// snapshotNodes records the content of every node of tree, including the
+// bytes of its path so that writes to shared backing arrays are detected.
+func snapshotNodes[T any](tree *Iradix[T]) map[*node[T]]nodeSnapshot[T] {
+	snapshot := map[*node[T]]nodeSnapshot[T]{}
+	var record func(n *node[T])
+	record = func(n *node[T]) {
+		snapshot[n] = nodeSnapshot[T]{
+			path:        slices.Clone(n.path),
+			val:         n.val,
+			children:    slices.Clone(n.children),
+			subtreeSize: n.subtreeSize,
+		}
+		for _, child := range n.children {
+			record(child)
+		}
+	}
+	record(tree.root)
+	return snapshot
+}
+
+func requireNodesUnchanged[T any](t *testing.T, snapshot map[*node[T]]nodeSnapshot[T]) {
+	t.Helper()
+	for n, expected := range snapshot {
+		require.Equal(t, expected.path, n.path, "path of node %q changed", expected.path)
+		require.Same(t, expected.val, n.val, "value of node %q changed", expected.path)
+		require.Equal(t, expected.children, n.children, "children of node %q changed", expected.path)
+		require.Equal(t, expected.subtreeSize, n.subtreeSize, "subtree size of node %q changed", expected.path)
+	}
+}
+
+func TestMutationsDoNotModifySharedNodes(t *testing.T) {
+	t.Parallel()
+
+	setup := testEntries("")
+
+	testCases := []struct {
+		name   string
+		mutate func(*testing.T, *Iradix[string]) *Iradix[string]
+	}{
+		{
+			name: "Split with new leaf",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace/pod-2/other", "val")
+			},
+		},
+		{
+			name: "Split with valued split node",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace/pod-2/own", "val")
+			},
+		},
+		{
+			name: "Insert below leaf",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustInsert(t, tree, "namespace/pod-1/container", "val")
+			},
+		},
+		{
+			name: "Delete merges parent with only child",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustDelete(t, tree, "namespace/pod-2/owner-1")
+			},
+		},
+		{
+			name: "Delete merges valueless node with only child",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustDelete(t, tree, "namespace/pod-1")
+			},
+		},
+		{
+			name: "Delete merges node with only child",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				return mustDelete(t, mustDelete(t, tree, "namespaces"), "namespace")
+			},
+		},
+		{
+			name: "Transaction with splits and merges",
+			mutate: func(t *testing.T, tree *Iradix[string]) *Iradix[string] {
+				txn := tree.txn()
+				txn.insert([]byte("namespace/pod-2/own"), "val")
+				txn.delete([]byte("namespace/pod-2/owner-1"))
+				txn.delete([]byte("namespace/pod-2/owner-2"))
+				txn.insert([]byte("namespace/pod-1/container"), "val")
+				txn.delete([]byte("namespace/pod-1"))
+				txn.delete([]byte("namespace"))
+				return txn.commit(tree)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tree := NewFromMap(setup)
+			snapshot := snapshotNodes(tree)
+
+			newTree := tc.mutate(t, tree)
+			validateTree(t, newTree)
+			requireNodesUnchanged(t, snapshot)
+			require.Equal(t, setup, tree.ToMap())
+
+			// Mutating the new tree further must not leak into the old one either
+			for key := range newTree.ToMap() {
+				newTree = mustDelete(t, newTree, key)
+				requireNodesUnchanged(t, snapshot)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package iradix
+
+import (
+	"encoding/binary"
+	"iter"
+)
+
+// Uint64Radix is an Iradix keyed by uint64s. Keys are stored big-endian, so
+// that the byte order of the underlying tree matches their numeric order.
+type Uint64Radix[T any] struct {
+	tree *Iradix[T]
+}
+
+// NewUint64 returns an empty tree keyed by uint64s.
+func NewUint64[T any]() *Uint64Radix[T] {
+	return &Uint64Radix[T]{tree: New[T]()}
+}
+
+// Get returns the value stored under key and whether it was present.
+func (u *Uint64Radix[T]) Get(key uint64) (T, bool) {
+	return u.tree.Get(encodeUint64(key))
+}
+
+// Insert stores val under key and returns the previously stored value, if
+// any, along with the resulting tree.
+func (u *Uint64Radix[T]) Insert(key uint64, val T) (oldVal T, existed bool, newTree *Uint64Radix[T]) {
+	oldVal, existed, tree := u.tree.Insert(encodeUint64(key), val)
+	return oldVal, existed, u.wrap(tree)
+}
+
+// Delete removes key and returns its value, if any, along with the resulting
+// tree.
+func (u *Uint64Radix[T]) Delete(key uint64) (oldVal T, existed bool, newTree *Uint64Radix[T]) {
+	oldVal, existed, tree := u.tree.Delete(encodeUint64(key))
+	return oldVal, existed, u.wrap(tree)
+}
+
+// Range yields all entries with lo <= key <= hi in ascending key order.
+func (u *Uint64Radix[T]) Range(lo, hi uint64) iter.Seq2[uint64, T] {
+	return func(yield func(uint64, T) bool) {
+		it := u.tree.Iterator()
+		it.SeekLowerBound(encodeUint64(lo))
+		for key, val, ok := it.Next(); ok; key, val, ok = it.Next() {
+			decoded := binary.BigEndian.Uint64(key)
+			if decoded > hi || !yield(decoded, val) {
+				return
+			}
+		}
+	}
+}
+
+// Iterate yields all entries in ascending key order.
+func (u *Uint64Radix[T]) Iterate() iter.Seq2[uint64, T] {
+	return func(yield func(uint64, T) bool) {
+		for key, val := range u.tree.Iterate() {
+			if !yield(binary.BigEndian.Uint64(key), val) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns the number of entries.
+func (u *Uint64Radix[T]) Len() int { return u.tree.Len() }
+
+// Tree returns the underlying byte-keyed tree.
+func (u *Uint64Radix[T]) Tree() *Iradix[T] { return u.tree }
+
+// wrap returns u if tree is unchanged so identity comparisons keep working.
+func (u *Uint64Radix[T]) wrap(tree *Iradix[T]) *Uint64Radix[T] {
+	if tree == u.tree {
+		return u
+	}
+	return &Uint64Radix[T]{tree: tree}
+}
+
+// encodeUint64 returns the big-endian representation of key.
+func encodeUint64(key uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, key)
+}
@@ -0,0 +1,83 @@
+package iradix
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUint64Radix(t *testing.T) {
+	t.Parallel()
+
+	keys := []uint64{256, 1, 0, math.MaxUint64, 255, 1 << 32}
+	tree := NewUint64[string]()
+	for _, key := range keys {
+		oldVal, existed, newTree := tree.Insert(key, "val")
+		require.False(t, existed)
+		require.Zero(t, oldVal)
+		validateTree(t, newTree.Tree())
+		tree = newTree
+	}
+	require.Equal(t, len(keys), tree.Len())
+
+	_, existed, sameTree := tree.Insert(255, "val")
+	require.True(t, existed)
+	require.Same(t, tree, sameTree)
+
+	_, exists := tree.Get(1 << 32)
+	require.True(t, exists)
+	_, exists = tree.Get(2)
+	require.False(t, exists)
+
+	var got []uint64
+	for key := range tree.Iterate() {
+		got = append(got, key)
+	}
+	slices.Sort(keys)
+	require.Equal(t, keys, got, "iteration should follow numeric order")
+
+	oldVal, existed, newTree := tree.Delete(256)
+	require.True(t, existed)
+	require.Equal(t, "val", oldVal)
+	validateTree(t, newTree.Tree())
+	require.Equal(t, len(keys)-1, newTree.Len())
+
+	_, existed, sameTree = newTree.Delete(256)
+	require.False(t, existed)
+	require.Same(t, newTree, sameTree)
+
+	_, exists = tree.Get(256)
+	require.True(t, exists, "original tree should be unmodified")
+}
+
+func TestUint64RadixRange(t *testing.T) {
+	t.Parallel()
+
+	tree := NewUint64[int]()
+	for _, key := range []uint64{0, 1, 255, 256, 1 << 32, math.MaxUint64} {
+		_, _, tree = tree.Insert(key, int(key%1000))
+	}
+
+	testCases := []struct {
+		lo, hi uint64
+		expect []uint64
+	}{
+		{lo: 0, hi: math.MaxUint64, expect: []uint64{0, 1, 255, 256, 1 << 32, math.MaxUint64}},
+		{lo: 1, hi: 256, expect: []uint64{1, 255, 256}},
+		{lo: 2, hi: 255, expect: []uint64{255}},
+		{lo: 257, hi: 1<<32 - 1},
+		{lo: 1 << 32, hi: math.MaxUint64, expect: []uint64{1 << 32, math.MaxUint64}},
+		{lo: 256, hi: 1},
+	}
+
+	for _, tc := range testCases {
+		var got []uint64
+		for key, val := range tree.Range(tc.lo, tc.hi) {
+			require.Equal(t, int(key%1000), val)
+			got = append(got, key)
+		}
+		require.Equal(t, tc.expect, got, "range [%d, %d]", tc.lo, tc.hi)
+	}
+}